@@ -0,0 +1,239 @@
+package buildkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+
+	"github.com/aFlyBird0/sshcontainer/grpcsock"
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// DefaultBuildkitdSocket is the buildkitd socket of root user
+const DefaultBuildkitdSocket = "/run/buildkit/buildkitd.sock"
+
+// defaultDialTimeout is the timeout of connecting to buildkitd
+const defaultDialTimeout = 10 * time.Second
+
+// RootlessSocket return the buildkitd socket of rootless user with uid
+func RootlessSocket(uid int) string {
+	return fmt.Sprintf("/run/user/%d/buildkit/buildkitd.sock", uid)
+}
+
+// ClientWithTunnel is buildkit client with tunnel.
+// Build sessions (local build context, secrets, ssh agent, etc.) are served over the grpc connection
+// of the client, so they go through the same ssh connection as the tunnel.
+type ClientWithTunnel struct {
+	*client.Client
+	buildkitOpts []client.ClientOpt
+	attachables  []session.Attachable // attached to the session of every Solve and Build
+
+	sock     *grpcsock.ClientWithTunnel // tunnel to buildkitd socket, its grpc connection is used for health check
+	sockOpts []grpcsock.Opt
+
+	log log.Logger
+}
+
+// Opt is option for ClientWithTunnel
+type Opt func(*ClientWithTunnel) error
+
+// NewClientWithTunnel create buildkit client with tunnel, localSocket and remoteSocket can be
+// "unix:///path/to/socket", "tcp://host:port" or a unix socket path without scheme.
+// If localSocket is empty, no local socket file is created and buildkit client dials remote socket over ssh directly
+func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	if err := c.apply(opts...); err != nil {
+		return nil, err
+	}
+	sock, err := grpcsock.NewClientWithTunnel(sshClient, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to buildkitd socket: %v", err)
+	}
+	return c.connect(sock)
+}
+
+// NewClientWithManager create buildkit client whose tunnel shares the ssh connection of manager,
+// the tunnel is registered in manager with name, and removed from manager by DoneAndWait.
+// If localSocket is empty, no tunnel is registered and buildkit client dials remote socket over the shared ssh connection
+func NewClientWithManager(manager *tunnel.Manager, name, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	if err := c.apply(opts...); err != nil {
+		return nil, err
+	}
+	sock, err := grpcsock.NewClientWithManager(manager, name, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to buildkitd socket: %v", err)
+	}
+	return c.connect(sock)
+}
+
+// apply options, the options of the tunnel are collected into sockOpts
+func (c *ClientWithTunnel) apply(opts ...Opt) error {
+	for _, opt := range opts {
+		// session attachables may fail to load, e.g. secret file doesn't exist
+		if err := opt(c); err != nil {
+			return err
+		}
+	}
+	if c.log == nil {
+		c.log = &log.NoopLogger{}
+	}
+	c.sockOpts = append(c.sockOpts, grpcsock.WithHealthCheck(listWorkers))
+	return nil
+}
+
+// connect create buildkit client dialing buildkitd socket through the tunnel of sock
+func (c *ClientWithTunnel) connect(sock *grpcsock.ClientWithTunnel) (*ClientWithTunnel, error) {
+	c.sock = sock
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	// options appended later take precedence, so the tunnel dialer can't be replaced by buildkitOpts
+	bopts := append(append([]client.ClientOpt{}, c.buildkitOpts...), client.WithContextDialer(sock.ContextDialer))
+	cl, err := client.New(ctx, sock.Target(), bopts...)
+	if err != nil {
+		c.DoneAndWait()
+		return nil, fmt.Errorf("failed to create buildkit client: %v", err)
+	}
+	c.Client = cl
+	c.log.Debugf("connected to buildkitd socket")
+
+	return c, nil
+}
+
+// listWorkers check buildkitd is ready to build, at least one worker is available
+func listWorkers(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := controlapi.NewControlClient(conn).ListWorkers(ctx, &controlapi.ListWorkersRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	if len(resp.Record) == 0 {
+		return errors.New("no worker is available")
+	}
+	return nil
+}
+
+// Solve calls Solve of buildkit client with session attachables of ClientWithTunnel,
+// LocalDirs of opt are synced from local host over the ssh connection
+func (c *ClientWithTunnel) Solve(ctx context.Context, def *llb.Definition, opt client.SolveOpt, statusChan chan *client.SolveStatus) (*client.SolveResponse, error) {
+	opt.Session = c.withAttachables(opt.Session)
+	return c.Client.Solve(ctx, def, opt, statusChan)
+}
+
+// Build calls Build of buildkit client with session attachables of ClientWithTunnel,
+// LocalDirs of opt are synced from local host over the ssh connection
+func (c *ClientWithTunnel) Build(ctx context.Context, opt client.SolveOpt, product string, buildFunc gateway.BuildFunc, statusChan chan *client.SolveStatus) (*client.SolveResponse, error) {
+	opt.Session = c.withAttachables(opt.Session)
+	return c.Client.Build(ctx, opt, product, buildFunc, statusChan)
+}
+
+// withAttachables return attachables of ClientWithTunnel followed by the given ones
+func (c *ClientWithTunnel) withAttachables(attachables []session.Attachable) []session.Attachable {
+	return append(append([]session.Attachable{}, c.attachables...), attachables...)
+}
+
+// DoneAndWait close buildkit client, stop tunnel and wait for it to exit
+func (c *ClientWithTunnel) DoneAndWait() {
+	if c.Client != nil {
+		c.Client.Close()
+	}
+	if c.sock != nil {
+		c.sock.DoneAndWait()
+	}
+}
+
+// WithLogger set logger for ClientWithTunnel
+func WithLogger(log log.Logger) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.log = log
+		c.sockOpts = append(c.sockOpts, grpcsock.WithLogger(log))
+		return nil
+	}
+}
+
+// WithAutoRemoveLocalSocket will remove local socket when tunnel exit
+func WithAutoRemoveLocalSocket(c *ClientWithTunnel) error {
+	c.sockOpts = append(c.sockOpts, grpcsock.WithAutoRemoveLocalSocket)
+	return nil
+}
+
+// WithDisableLogger disable all log output
+func WithDisableLogger(c *ClientWithTunnel) error {
+	c.log = &log.NoopLogger{}
+	c.sockOpts = append(c.sockOpts, grpcsock.WithDisableLogger)
+	return nil
+}
+
+// WithBuildkitClientOpts set origin buildkit client options
+func WithBuildkitClientOpts(opts ...client.ClientOpt) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.buildkitOpts = opts
+		return nil
+	}
+}
+
+// WithSessionAttachables attach custom session attachables (e.g. auth provider) to every Solve and Build
+func WithSessionAttachables(attachables ...session.Attachable) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.attachables = append(c.attachables, attachables...)
+		return nil
+	}
+}
+
+// WithSecrets expose local secrets to builds, like `--secret id=xxx,src=path`, key of files is secret id,
+// value is path of the local file
+func WithSecrets(files map[string]string) Opt {
+	return func(c *ClientWithTunnel) error {
+		sources := make([]secretsprovider.Source, 0, len(files))
+		for id, path := range files {
+			sources = append(sources, secretsprovider.Source{ID: id, FilePath: path})
+		}
+		store, err := secretsprovider.NewStore(sources)
+		if err != nil {
+			return fmt.Errorf("failed to load secrets: %v", err)
+		}
+		c.attachables = append(c.attachables, secretsprovider.NewSecretProvider(store))
+		return nil
+	}
+}
+
+// WithSSHAgent forward local ssh agent or keys to builds with id, like `--ssh id=path`,
+// $SSH_AUTH_SOCK is used if no path is given
+func WithSSHAgent(id string, paths ...string) Opt {
+	return func(c *ClientWithTunnel) error {
+		provider, err := sshprovider.NewSSHAgentProvider([]sshprovider.AgentConfig{{ID: id, Paths: paths}})
+		if err != nil {
+			return fmt.Errorf("failed to load ssh agent: %v", err)
+		}
+		c.attachables = append(c.attachables, provider)
+		return nil
+	}
+}
+
+// WithPingRetry set max retry for connecting to buildkitd socket, default is 3
+func WithPingRetry(maxRetry uint) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithPingRetry(maxRetry))
+		return nil
+	}
+}
+
+// WithKeepAlive set keepalive interval and max missed replies of the ssh connection, default is 30s and 3
+func WithKeepAlive(interval time.Duration, maxMissed int) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithKeepAlive(interval, maxMissed))
+		return nil
+	}
+}
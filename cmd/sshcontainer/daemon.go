@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aFlyBird0/sshcontainer/daemon"
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// detachedEnv is set for the daemon process started by -detach
+const detachedEnv = "SSHCONTAINER_DETACHED"
+
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	var (
+		sshFlags      sshFlags
+		controlSocket string
+		detach        bool
+		verbose       bool
+	)
+	sshFlags.register(fs)
+	fs.StringVar(&controlSocket, "socket", daemon.DefaultControlSocket(), "control `socket` of the daemon")
+	fs.BoolVar(&detach, "detach", false, "run in background, logs are written to daemon.log next to the control socket")
+	fs.BoolVar(&verbose, "v", false, "print debug logs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: sshcontainer daemon [flags]\n\n"+
+			"Keep forwards to several hosts open until SIGINT, SIGTERM or 'sshcontainer ctl stop',\n"+
+			"forwards are added and removed by 'sshcontainer ctl'. Flags of ssh apply to all hosts,\n"+
+			"the password is read from $%s.\n\nFlags:\n", passwordEnv)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if detach && os.Getenv(detachedEnv) == "" {
+		return detachDaemon(controlSocket)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	if verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	server := daemon.NewServer(controlSocket, sshFlags.dial).SetLogger(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.Serve(ctx)
+}
+
+// detachDaemon start the daemon with the same args in a new session, and wait until it serves the control socket
+func detachDaemon(controlSocket string) error {
+	if err := daemon.PrepareControlDir(filepath.Dir(controlSocket)); err != nil {
+		return err
+	}
+	logFile := filepath.Join(filepath.Dir(controlSocket), "daemon.log")
+	logs, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer logs.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	client := daemon.NewClient(controlSocket).SetTimeout(time.Second)
+	for {
+		select {
+		case <-exited:
+			return fmt.Errorf("daemon exited, see %s", logFile)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if _, err := client.List(); err == nil {
+			fmt.Printf("daemon is running (pid %d), logs are written to %s\n", cmd.Process.Pid, logFile)
+			return nil
+		}
+	}
+}
+
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	var (
+		controlSocket string
+		jsonOutput    bool
+	)
+	fs.StringVar(&controlSocket, "socket", daemon.DefaultControlSocket(), "control `socket` of the daemon")
+	fs.BoolVar(&jsonOutput, "json", false, "print status as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: sshcontainer ctl [flags] <operation> [args]\n\n"+
+			"Control the daemon started by 'sshcontainer daemon'.\n\nOperations:\n"+
+			"  list                                      list forwards\n"+
+			"  status [NAME]                             show forwards, exit 1 if one is exited or reconnecting\n"+
+			"  add [-name NAME] [-local SOCKET] HOST [REMOTE_SOCKET]\n"+
+			"                                            forward REMOTE_SOCKET (default %s) on HOST\n"+
+			"  remove NAME                               remove the forward\n"+
+			"  active                                    print the forward $DOCKER_HOST points to, for shell prompts\n"+
+			"  stop                                      stop the daemon and remove all forwards\n\nFlags:\n",
+			docker.DefaultDockerSock)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	client := daemon.NewClient(controlSocket)
+	op, rest := fs.Arg(0), fs.Args()[1:]
+
+	switch op {
+	case daemon.OpList, daemon.OpStatus:
+		if len(rest) > 1 || (op == daemon.OpList && len(rest) > 0) {
+			fs.Usage()
+			os.Exit(2)
+		}
+		req := daemon.Request{Op: op}
+		if len(rest) == 1 {
+			req.Name = rest[0]
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		if err := printForwards(resp.Forwards, jsonOutput); err != nil {
+			return err
+		}
+		if op == daemon.OpStatus {
+			for _, f := range resp.Forwards {
+				if !f.Running || (f.State != tunnel.StateConnected.String() && f.State != tunnel.StateIdle.String()) {
+					return &exitError{code: 1}
+				}
+			}
+		}
+		return nil
+	case daemon.OpAdd:
+		return ctlAdd(client, rest, jsonOutput)
+	case daemon.OpRemove:
+		if len(rest) != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		return client.Remove(rest[0])
+	case daemon.OpStop:
+		return client.Stop()
+	case "active":
+		return ctlActive(client, jsonOutput)
+	default:
+		fmt.Fprintf(os.Stderr, "sshcontainer ctl: unknown operation %q\n", op)
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// ctlAdd add a forward and print the line to use it
+func ctlAdd(client *daemon.Client, args []string, jsonOutput bool) error {
+	fs := flag.NewFlagSet("ctl add", flag.ExitOnError)
+	var name, localSocket, envName string
+	fs.StringVar(&name, "name", "", "`name` of the forward, default is HOST")
+	fs.StringVar(&localSocket, "local", "", "local `socket`, default is NAME.sock next to the control socket")
+	fs.StringVar(&envName, "env", envDockerHost, "`name` of the environment variable printed for clients")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintf(fs.Output(), "Usage: sshcontainer ctl add [flags] HOST [REMOTE_SOCKET]\n\nFlags:\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	remoteSocket := docker.DefaultDockerSock
+	if fs.NArg() == 2 {
+		remoteSocket = fs.Arg(1)
+	}
+
+	status, err := client.Add(name, fs.Arg(0), remoteSocket, localSocket)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printForwards([]daemon.ForwardStatus{status}, true)
+	}
+	fmt.Printf("export %s=%s\n", envName, status.Local)
+	return nil
+}
+
+// ctlActive print the forward whose local socket is $DOCKER_HOST, nothing is printed if there's none,
+// so it can be used in shell prompts
+func ctlActive(client *daemon.Client, jsonOutput bool) error {
+	dockerHost := os.Getenv(envDockerHost)
+	if dockerHost == "" {
+		return nil
+	}
+	active, err := tunnel.ParseAddr(dockerHost)
+	if err != nil {
+		return nil
+	}
+	forwards, err := client.List()
+	if err != nil {
+		return err
+	}
+	for _, f := range forwards {
+		local, err := tunnel.ParseAddr(f.Local)
+		if err != nil || local.String() != active.String() {
+			continue
+		}
+		if jsonOutput {
+			return printForwards([]daemon.ForwardStatus{f}, true)
+		}
+		fmt.Printf("%s (%s)\n", f.Name, f.State)
+		return nil
+	}
+	return nil
+}
+
+func printForwards(forwards []daemon.ForwardStatus, jsonOutput bool) error {
+	if jsonOutput {
+		if forwards == nil {
+			forwards = []daemon.ForwardStatus{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(forwards)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHOST\tSTATE\tCONNECTIONS\tLOCAL\tREMOTE")
+	for _, f := range forwards {
+		state := f.State
+		if !f.Running {
+			state = "exited"
+			if f.Error != "" {
+				state += ": " + strings.ReplaceAll(f.Error, "\t", " ")
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", f.Name, f.Host, state, f.ActiveConnections, f.Local, f.Remote)
+	}
+	return w.Flush()
+}
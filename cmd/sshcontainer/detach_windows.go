@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// detachedProcAttr start the daemon in a new process group, so ctrl-c of the console isn't delivered to it
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
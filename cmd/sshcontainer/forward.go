@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+func runForward(args []string) error {
+	fs := flag.NewFlagSet("forward", flag.ExitOnError)
+	var (
+		sshFlags    sshFlags
+		localSocket string
+		envName     string
+		verbose     bool
+	)
+	sshFlags.register(fs)
+	fs.StringVar(&localSocket, "local", "", "local `socket`, unix:///path, tcp://host:port or a path, default is a socket in a temp dir")
+	fs.StringVar(&envName, "env", "DOCKER_HOST", "`name` of the environment variable printed for clients, e.g. CONTAINERD_ADDRESS")
+	fs.BoolVar(&verbose, "v", false, "print debug logs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: sshcontainer forward [flags] HOST [REMOTE_SOCKET]\n\n"+
+			"Forward REMOTE_SOCKET (default %s) on HOST to a local socket until SIGINT or SIGTERM.\n"+
+			"HOST is [user@]host[:port] or an alias of ~/.ssh/config, the password is read from $%s.\n\nFlags:\n",
+			docker.DefaultDockerSock, passwordEnv)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	remoteSocket := docker.DefaultDockerSock
+	if fs.NArg() == 2 {
+		remoteSocket = fs.Arg(1)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	if verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	if localSocket == "" {
+		dir, err := os.MkdirTemp("", "sshcontainer-")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		localSocket = filepath.Join(dir, filepath.Base(strings.TrimPrefix(remoteSocket, "unix://")))
+	}
+	local, err := tunnel.ParseAddr(localSocket)
+	if err != nil {
+		return fmt.Errorf("invalid local socket: %v", err)
+	}
+	remote, err := tunnel.ParseAddr(remoteSocket)
+	if err != nil {
+		return fmt.Errorf("invalid remote socket: %v", err)
+	}
+
+	sshClient, factory, err := sshFlags.dial(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := checkRemoteSocket(sshClient, remote); err != nil {
+		sshClient.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	socketTunnel := tunnel.NewSocketTunnelWithClient(local.String(), remote.String(), sshClient, factory).
+		SetLogger(logger).
+		AutoRemoveLocalSocket()
+	errc, err := socketTunnel.Start(ctx)
+	if err != nil {
+		socketTunnel.Stop()
+		return fmt.Errorf("failed to start tunnel: %v", err)
+	}
+	// clients may reach the remote socket through the tunnel from now on
+	fmt.Printf("export %s=%s\n", envName, socketTunnel.LocalAddr())
+	logger.Infof("forwarding %s to %s, press Ctrl+C to stop", socketTunnel.LocalAddr(), remote)
+
+	select {
+	case <-ctx.Done():
+		logger.Infof("stopping tunnel")
+		err = nil
+	case err = <-errc:
+	}
+	socketTunnel.Stop()
+	return err
+}
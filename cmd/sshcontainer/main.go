@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of sshcontainer
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{name: "forward", summary: "forward a remote socket to a local socket over ssh", run: runForward},
+	{name: "run", summary: "run a command with container clients pointing to a remote host", run: runRun},
+	{name: "daemon", summary: "keep forwards to several hosts open, controlled by 'sshcontainer ctl'", run: runDaemon},
+	{name: "ctl", summary: "list, add, remove and show status of forwards of the daemon", run: runCtl},
+}
+
+func main() {
+	logrus.SetOutput(os.Stderr)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				var exitErr *exitError
+				if errors.As(err, &exitErr) {
+					os.Exit(exitErr.code)
+				}
+				fmt.Fprintf(os.Stderr, "sshcontainer %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	if os.Args[1] != "-h" && os.Args[1] != "--help" && os.Args[1] != "help" {
+		fmt.Fprintf(os.Stderr, "sshcontainer: unknown command %q\n", os.Args[1])
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sshcontainer <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'sshcontainer <command> -h' for flags of a command.\n")
+}
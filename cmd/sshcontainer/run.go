@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/discovery"
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// environment variables of container clients
+const (
+	envDockerHost        = "DOCKER_HOST"                // docker, compose, and podman's docker api
+	envContainerHost     = "CONTAINER_HOST"             // podman remote
+	envContainerdAddress = "CONTAINERD_ADDRESS"         // ctr and nerdctl, it's a path instead of url
+	envRuntimeEndpoint   = "CONTAINER_RUNTIME_ENDPOINT" // crictl
+)
+
+// forwardedSocket is a remote socket whose local socket is set to an environment variable of the child
+type forwardedSocket struct {
+	env    string
+	remote string
+}
+
+// exitError make main exit with code silently, it's the exit code of the child
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var (
+		sshFlags         sshFlags
+		dockerSocket     string
+		containerdSocket string
+		podmanSocket     string
+		discover         bool
+		verbose          bool
+	)
+	sshFlags.register(fs)
+	fs.StringVar(&dockerSocket, "docker", "", "remote docker `socket` set to "+envDockerHost+", default is "+docker.DefaultDockerSock+" if no socket is given")
+	fs.StringVar(&containerdSocket, "containerd", "", "remote containerd `socket` set to "+envContainerdAddress)
+	fs.StringVar(&podmanSocket, "podman", "", "remote podman `socket` set to "+envContainerHost)
+	fs.BoolVar(&discover, "discover", false, "discover runtimes on the host and forward all available ones")
+	fs.BoolVar(&verbose, "v", false, "print debug logs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: sshcontainer run [flags] HOST -- COMMAND [ARGS...]\n\n"+
+			"Run COMMAND with %s, %s or %s pointing to sockets forwarded from HOST,\n"+
+			"the sockets are removed after COMMAND exits, and the exit code of COMMAND is returned.\n"+
+			"HOST is [user@]host[:port] or an alias of ~/.ssh/config, the password is read from $%s.\n\nFlags:\n",
+			envDockerHost, envContainerdAddress, envContainerHost, passwordEnv)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) > 1 && rest[1] == "--" {
+		rest = append(rest[:1], rest[2:]...)
+	}
+	if len(rest) < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	host, command := rest[0], rest[1:]
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	// logs of tunnels shouldn't be mixed with output of the command
+	logger.SetLevel(logrus.WarnLevel)
+	if verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	sshClient, factory, err := sshFlags.dial(host)
+	if err != nil {
+		return err
+	}
+
+	var sockets []forwardedSocket
+	if discover {
+		sockets, err = discoverSockets(sshClient, logger)
+		if err != nil {
+			sshClient.Close()
+			return err
+		}
+	}
+	for _, s := range []forwardedSocket{
+		{env: envDockerHost, remote: dockerSocket},
+		{env: envContainerdAddress, remote: containerdSocket},
+		{env: envContainerHost, remote: podmanSocket},
+	} {
+		if s.remote != "" {
+			sockets = append(sockets, s)
+		}
+	}
+	if len(sockets) == 0 && !discover {
+		sockets = []forwardedSocket{{env: envDockerHost, remote: docker.DefaultDockerSock}}
+	}
+	if len(sockets) == 0 {
+		sshClient.Close()
+		return errors.New("no available container runtime is found on the host")
+	}
+	for _, s := range sockets {
+		remote, err := tunnel.ParseAddr(s.remote)
+		if err == nil {
+			err = checkRemoteSocket(sshClient, remote)
+		}
+		if err != nil {
+			sshClient.Close()
+			return err
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "sshcontainer-")
+	if err != nil {
+		sshClient.Close()
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// all tunnels share one ssh connection, they are stopped before the temp dir is removed
+	manager := tunnel.NewManagerWithClient(sshClient, factory).SetLogger(logger)
+	defer manager.Close()
+	env, err := startTunnels(manager, dir, sockets)
+	if err != nil {
+		return err
+	}
+
+	return runChild(command, env, logger)
+}
+
+// startTunnels start a tunnel for every remote socket in dir, and return environment variables of the child
+func startTunnels(manager *tunnel.Manager, dir string, sockets []forwardedSocket) ([]string, error) {
+	locals := map[string]string{} // remote socket -> local socket, a remote socket may be set to several variables
+	var env []string
+	for _, s := range sockets {
+		local, ok := locals[s.remote]
+		if !ok {
+			// remote sockets are checked before
+			remote, _ := tunnel.ParseAddr(s.remote)
+			local = filepath.Join(dir, fmt.Sprintf("%d-%s", len(locals), filepath.Base(remote.Address)))
+			socketTunnel := manager.NewSocketTunnel(local, remote.String()).AutoRemoveLocalSocket()
+			if err := manager.Add(s.remote, socketTunnel); err != nil {
+				return nil, err
+			}
+			locals[s.remote] = local
+		}
+
+		if s.env == envContainerdAddress {
+			env = append(env, s.env+"="+local)
+		} else {
+			env = append(env, s.env+"=unix://"+local)
+		}
+	}
+	return env, nil
+}
+
+// discoverSockets return sockets of available runtimes on the host, the first runtime of each capability is used
+func discoverSockets(sshClient *ssh.Client, logger *logrus.Logger) ([]forwardedSocket, error) {
+	runtimes, err := discovery.Discover(sshClient, discovery.WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []forwardedSocket
+	if r, ok := discovery.Best(runtimes, discovery.CapabilityDockerAPI); ok {
+		sockets = append(sockets, forwardedSocket{env: envDockerHost, remote: r.Socket})
+	}
+	for _, r := range runtimes {
+		if r.Available() && r.Kind == discovery.KindPodman {
+			sockets = append(sockets, forwardedSocket{env: envContainerHost, remote: r.Socket})
+			break
+		}
+	}
+	if r, ok := discovery.Best(runtimes, discovery.CapabilityContainerdAPI); ok {
+		sockets = append(sockets, forwardedSocket{env: envContainerdAddress, remote: r.Socket})
+	}
+	if r, ok := discovery.Best(runtimes, discovery.CapabilityCRI); ok {
+		sockets = append(sockets, forwardedSocket{env: envRuntimeEndpoint, remote: r.Socket})
+	}
+	for _, s := range sockets {
+		logger.Infof("found %s for %s", s.remote, s.env)
+	}
+	return sockets, nil
+}
+
+// runChild run command with env, forward signals to it, and return exitError with its exit code
+func runChild(command, env []string, logger *logrus.Logger) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	for _, e := range env {
+		logger.Debugf("set %s", e)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sshcontainer run: %v\n", err)
+		return &exitError{code: 127}
+	}
+	go func() {
+		for sig := range signals {
+			if deliveredByTerminal(sig) {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			// like shells, the exit code of a process killed by signal is 128 + signal
+			code = 128 + int(status.Signal())
+		}
+		return &exitError{code: code}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to wait for command: %v", err)
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,12 @@
+package main
+
+import "os"
+
+// forwardedSignals are caught so that run waits for the child to exit, only interrupt can be caught on windows
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// deliveredByTerminal is always true, ctrl-c is delivered to all processes attached to the console
+// and signals can't be sent to other processes on windows
+func deliveredByTerminal(os.Signal) bool {
+	return true
+}
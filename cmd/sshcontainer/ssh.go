@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/sshclient"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// environment variables of secrets, they aren't accepted as flags so they don't appear in the process list
+const (
+	passwordEnv   = "SSHCONTAINER_PASSWORD"
+	passphraseEnv = "SSHCONTAINER_PASSPHRASE"
+)
+
+// sshFlags is flags of connecting to ssh host, the host is resolved through ~/.ssh/config like the ssh command
+type sshFlags struct {
+	identity  string
+	jump      string
+	acceptNew bool
+	insecure  bool
+}
+
+func (f *sshFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.identity, "i", "", "private key `file`, tried before keys of ssh config and ssh-agent, its passphrase is read from $"+passphraseEnv)
+	fs.StringVar(&f.jump, "J", "", "comma separated jump `hosts` like ssh -J, overriding ProxyJump of ssh config")
+	fs.BoolVar(&f.acceptNew, "accept-new", false, "add unknown host keys to known_hosts, like StrictHostKeyChecking=accept-new")
+	fs.BoolVar(&f.insecure, "insecure", false, "don't verify host keys, only for testing")
+}
+
+// dial connect to target and return a factory redialing it, target is "[user@]host[:port]", "ssh://[user@]host[:port]"
+// or an alias of ssh config. The first client is dialed immediately to report errors early
+func (f *sshFlags) dial(target string) (*ssh.Client, tunnel.ClientFactory, error) {
+	hostConfig, err := f.hostConfig(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts, err := hostConfig.Opts()
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.identity != "" {
+		opts = append([]sshclient.Opt{sshclient.WithPrivateKeyFile(f.identity, os.Getenv(passphraseEnv))}, opts...)
+	}
+	if password := os.Getenv(passwordEnv); password != "" {
+		opts = append(opts, sshclient.WithPassword(password), sshclient.WithKeyboardInteractivePassword(password))
+	}
+
+	factory := func() (*ssh.Client, error) {
+		return sshclient.Dial(hostConfig.Addr(), hostConfig.User, opts...)
+	}
+	client, err := factory()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, factory, nil
+}
+
+// hostConfig load ssh config of target, user, port and flags take precedence
+func (f *sshFlags) hostConfig(target string) (*sshclient.HostConfig, error) {
+	if !strings.HasPrefix(target, "ssh://") {
+		target = "ssh://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("invalid host %q, it should be [user@]host[:port]", target)
+	}
+
+	hostConfig, err := sshclient.LoadHostConfig(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if u.User != nil && u.User.Username() != "" {
+		hostConfig.User = u.User.Username()
+	}
+	if u.Port() != "" {
+		hostConfig.Port = u.Port()
+	}
+	if f.jump != "" {
+		hostConfig.ProxyJump = strings.Split(f.jump, ",")
+	}
+	switch {
+	case f.insecure:
+		hostConfig.StrictHostKeyChecking = "no"
+	case f.acceptNew:
+		hostConfig.StrictHostKeyChecking = "accept-new"
+	}
+	return hostConfig, nil
+}
+
+// checkRemoteSocket connect to remote socket to fail early if it can't be used, e.g. permission denied
+func checkRemoteSocket(sshClient *ssh.Client, remote tunnel.Addr) error {
+	conn, err := sshClient.Dial(remote.Network, remote.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to remote socket %s: %v", remote, err)
+	}
+	conn.Close()
+	return nil
+}
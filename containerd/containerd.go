@@ -3,116 +3,209 @@ package containerd
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/containerd/containerd"
+	namespacesapi "github.com/containerd/containerd/api/services/namespaces/v1"
+	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/namespaces"
 	"golang.org/x/crypto/ssh"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/aFlyBird0/sshcontainer/grpcsock"
 	"github.com/aFlyBird0/sshcontainer/log"
 	"github.com/aFlyBird0/sshcontainer/tunnel"
 )
 
 const DefaultContainerdSocket = "/run/containerd/containerd.sock"
 
+// DefaultDiscoveryNamespaces is the preferred order of namespace discovery:
+// namespace of kubernetes, docker and ctr
+var DefaultDiscoveryNamespaces = []string{"k8s.io", "moby", namespaces.Default}
+
 // ClientWithTunnel is containerd client with tunnel
 type ClientWithTunnel struct {
 	*containerd.Client
 	containerdOpts []containerd.ClientOpt
 
-	socketTunnel *tunnel.SocketTunnel
+	sock     *grpcsock.ClientWithTunnel // grpc connection to containerd socket
+	sockOpts []grpcsock.Opt
+
+	namespace string   // namespace added to requests without one
+	explicit  bool     // namespace is set by WithNamespace
+	discover  []string // preferred namespaces of discovery, nil if discovery is disabled
 
-	maxRetry uint
-	log      log.Logger
+	log log.Logger
 }
 
 // Opt is option for ClientWithTunnel
 type Opt func(*ClientWithTunnel) error
 
-// NewClientWithTunnel create containerd client with tunnel
+// NewClientWithTunnel create containerd client with tunnel,
+// if localSocket is empty, no local socket file is created and containerd client dials remote socket over ssh directly
 func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
-	tunnel := tunnel.NewSocketTunnel(localSocket, remoteSocket, sshClient)
-	c := &ClientWithTunnel{
-		socketTunnel: tunnel,
+	c := &ClientWithTunnel{}
+	c.apply(opts...)
+	sock, err := grpcsock.NewClientWithTunnel(sshClient, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to containerd socket: %v", err)
+	}
+	return c.connect(sock)
+}
+
+// NewClientWithManager create containerd client whose tunnel shares the ssh connection of manager,
+// the tunnel is registered in manager with name, and removed from manager by DoneAndWait.
+// If localSocket is empty, no tunnel is registered and containerd client dials remote socket over the shared ssh connection
+func NewClientWithManager(manager *tunnel.Manager, name, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	c.apply(opts...)
+	sock, err := grpcsock.NewClientWithManager(manager, name, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to containerd socket: %v", err)
 	}
+	return c.connect(sock)
+}
+
+// apply options, the options of grpc connection are collected into sockOpts
+func (c *ClientWithTunnel) apply(opts ...Opt) {
 	for _, opt := range opts {
 		opt(c)
 	}
 	if c.log == nil {
 		c.log = &log.NoopLogger{}
 	}
-	c.socketTunnel.SetLogger(c.log)
-	if c.maxRetry == 0 {
-		c.maxRetry = 3
-	}
-	go func() {
-		if err := tunnel.Start(); err != nil {
-			c.log.Errorf("failed to start containerd socket tunnel: %v", err)
-			// no need to exit because containerd client will retry and report error
-		}
-	}()
+	// containerd.NewWithConn doesn't add default namespace to requests like containerd.New does
+	c.sockOpts = append(c.sockOpts, grpcsock.WithDialOpts(
+		grpc.WithChainUnaryInterceptor(c.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(c.streamInterceptor)))
+}
 
-	socketPath := localSocket
-	c.log.Debugf("socketPath: %s", socketPath)
+// connect resolve namespace and create containerd client on the grpc connection
+func (c *ClientWithTunnel) connect(sock *grpcsock.ClientWithTunnel) (*ClientWithTunnel, error) {
+	c.sock = sock
 
-	cl, err := containerd.New(socketPath, c.containerdOpts...)
+	// default namespace of containerd client options takes precedence over discovered one,
+	// but not the one set by WithNamespace
+	namespace := c.namespace
+	if !c.explicit {
+		// $CONTAINERD_NAMESPACE or "default", same as ctr
+		namespace, _ = namespaces.Namespace(namespaces.NamespaceFromEnv(context.Background()))
+		if c.discover != nil {
+			discovered, err := c.discoverNamespace(namespace)
+			if err != nil {
+				c.DoneAndWait()
+				return nil, err
+			}
+			namespace = discovered
+		}
+	}
+	var opts []containerd.ClientOpt
+	if !c.explicit {
+		opts = append(opts, containerd.WithDefaultNamespace(namespace))
+	}
+	opts = append(opts, c.containerdOpts...)
+	if c.explicit {
+		opts = append(opts, containerd.WithDefaultNamespace(namespace))
+	}
+
+	cl, err := containerd.NewWithConn(sock.ClientConn, opts...)
+	if errdefs.IsNotFound(err) {
+		// the namespace doesn't exist until it's used for the first time, so its labels of
+		// default runtime can't be read. Keep the namespace and use the default runtime instead
+		cl, err = containerd.NewWithConn(sock.ClientConn, append(opts, containerd.WithDefaultRuntime(defaults.DefaultRuntime))...)
+	}
 	if err != nil {
+		c.DoneAndWait()
 		return nil, fmt.Errorf("failed to create containerd client: %v", err)
 	}
+	c.namespace = cl.DefaultNamespace()
 	c.Client = cl
-
-	// try to connect to containerd socket
-	if err := c.pingWithRetry(); err != nil {
-		return nil, err
-	}
+	c.log.Debugf("connected to containerd socket, namespace: %s", c.namespace)
 
 	return c, nil
 }
 
-// WithMaxRetry set max retry for connecting to containerd socket
-func (c *ClientWithTunnel) pingWithRetry() error {
-	for i := uint(0); i < c.maxRetry; i++ {
-		if i != 0 {
-			time.Sleep(1 * time.Second)
-		}
+// discoverNamespace return the first existing namespace in c.discover,
+// or the first namespace in alphabetical order if none of them exists,
+// fallback is returned if there is no namespace at all
+func (c *ClientWithTunnel) discoverNamespace(fallback string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := namespacesapi.NewNamespacesClient(c.sock.ClientConn).List(ctx, &namespacesapi.ListNamespacesRequest{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containerd namespaces: %v", err)
+	}
 
-		// todo: namespace
-		ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
-		if _, err := c.Client.HealthService().Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true)); err == nil {
-			c.log.Debugf("connected to containerd socket")
-			return nil
+	existing := make(map[string]bool, len(resp.Namespaces))
+	names := make([]string, 0, len(resp.Namespaces))
+	for _, ns := range resp.Namespaces {
+		existing[ns.Name] = true
+		names = append(names, ns.Name)
+	}
+	for _, ns := range c.discover {
+		if existing[ns] {
+			return ns, nil
 		}
+	}
+	if len(names) == 0 {
+		return fallback, nil
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
 
-		c.log.Debugf("failed to connect to containerd socket, retrying...")
+// Namespace return the default namespace of requests
+func (c *ClientWithTunnel) Namespace() string {
+	return c.namespace
+}
+
+// Context return ctx with the default namespace if ctx has no namespace, for functions
+// requiring a namespace-scoped context rather than calling through the client, e.g. images.Handlers
+func (c *ClientWithTunnel) Context(ctx context.Context) context.Context {
+	if _, ok := namespaces.Namespace(ctx); ok || c.namespace == "" {
+		return ctx
 	}
+	return namespaces.WithNamespace(ctx, c.namespace)
+}
 
-	return fmt.Errorf("failed to connect to containerd socket")
+func (c *ClientWithTunnel) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(c.Context(ctx), method, req, reply, cc, opts...)
+}
+
+func (c *ClientWithTunnel) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(c.Context(ctx), desc, cc, method, opts...)
 }
 
 // DoneAndWait stop tunnel and wait for it to exit
 func (c *ClientWithTunnel) DoneAndWait() {
-	c.socketTunnel.Stop()
+	if c.sock != nil {
+		c.sock.DoneAndWait()
+	}
 }
 
 // WithLogger set logger for ClientWithTunnel
 func WithLogger(log log.Logger) Opt {
 	return func(c *ClientWithTunnel) error {
 		c.log = log
+		c.sockOpts = append(c.sockOpts, grpcsock.WithLogger(log))
 		return nil
 	}
 }
 
 // WithAutoRemoveLocalSocket will remove local socket when tunnel exit
 func WithAutoRemoveLocalSocket(c *ClientWithTunnel) error {
-	c.socketTunnel.AutoRemoveLocalSocket()
+	c.sockOpts = append(c.sockOpts, grpcsock.WithAutoRemoveLocalSocket)
 	return nil
 }
 
 // WithDisableLogger disable all log output
 func WithDisableLogger(c *ClientWithTunnel) error {
 	c.log = &log.NoopLogger{}
+	c.sockOpts = append(c.sockOpts, grpcsock.WithDisableLogger)
 	return nil
 }
 
@@ -124,10 +217,50 @@ func WithContainerdClientOpts(opts ...containerd.ClientOpt) Opt {
 	}
 }
 
+// WithNamespace set the default namespace of requests, it takes precedence over
+// containerd.WithDefaultNamespace in WithContainerdClientOpts and namespace discovery
+func WithNamespace(namespace string) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.namespace = namespace
+		c.explicit = true
+		return nil
+	}
+}
+
+// WithNamespaceDiscovery use the first existing namespace in preferred as the default namespace,
+// DefaultDiscoveryNamespaces is used if preferred is empty.
+// If none of them exists, the first namespace in alphabetical order is used.
+// Without WithNamespace and WithNamespaceDiscovery, the default namespace is $CONTAINERD_NAMESPACE or "default"
+func WithNamespaceDiscovery(preferred ...string) Opt {
+	return func(c *ClientWithTunnel) error {
+		if len(preferred) == 0 {
+			preferred = DefaultDiscoveryNamespaces
+		}
+		c.discover = preferred
+		return nil
+	}
+}
+
+// WithDialOpts append grpc dial options of the connection to containerd socket
+func WithDialOpts(opts ...grpc.DialOption) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithDialOpts(opts...))
+		return nil
+	}
+}
+
 // WithPingRetry set max retry for connecting to containerd socket, default is 3
 func WithPingRetry(maxRetry uint) Opt {
 	return func(c *ClientWithTunnel) error {
-		c.maxRetry = maxRetry
+		c.sockOpts = append(c.sockOpts, grpcsock.WithPingRetry(maxRetry))
+		return nil
+	}
+}
+
+// WithKeepAlive set keepalive interval and max missed replies of the ssh connection, default is 30s and 3
+func WithKeepAlive(interval time.Duration, maxMissed int) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithKeepAlive(interval, maxMissed))
 		return nil
 	}
 }
@@ -0,0 +1,242 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/aFlyBird0/sshcontainer/grpcsock"
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+const (
+	// DefaultContainerdSocket is the CRI socket of containerd
+	DefaultContainerdSocket = "/run/containerd/containerd.sock"
+	// DefaultCRIOSocket is the CRI socket of CRI-O
+	DefaultCRIOSocket = "/var/run/crio/crio.sock"
+)
+
+// APIVersion is the version of CRI api
+type APIVersion string
+
+const (
+	// APIVersionV1 is CRI v1, supported by containerd >= 1.6 and CRI-O >= 1.23
+	APIVersionV1 APIVersion = "v1"
+	// APIVersionV1alpha2 is CRI v1alpha2, which is wire compatible with v1 and deprecated
+	APIVersionV1alpha2 APIVersion = "v1alpha2"
+)
+
+// ClientWithTunnel is CRI client with tunnel, it embeds both RuntimeService and ImageService clients
+type ClientWithTunnel struct {
+	runtimeapi.RuntimeServiceClient
+	runtimeapi.ImageServiceClient
+
+	apiVersion APIVersion // negotiated or set by WithAPIVersion
+
+	sock     *grpcsock.ClientWithTunnel // grpc connection to CRI socket
+	sockOpts []grpcsock.Opt
+
+	log log.Logger
+}
+
+// Opt is option for ClientWithTunnel
+type Opt func(*ClientWithTunnel) error
+
+// NewClientWithTunnel create CRI client with tunnel, localSocket and remoteSocket can be
+// "unix:///path/to/socket", "tcp://host:port" or a unix socket path without scheme.
+// If localSocket is empty, no local socket file is created and CRI client dials remote socket over ssh directly
+func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	c.apply(opts...)
+	sock, err := grpcsock.NewClientWithTunnel(sshClient, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CRI socket: %v", err)
+	}
+	c.sock = sock
+	return c, nil
+}
+
+// NewClientWithManager create CRI client whose tunnel shares the ssh connection of manager,
+// the tunnel is registered in manager with name, and removed from manager by DoneAndWait.
+// If localSocket is empty, no tunnel is registered and CRI client dials remote socket over the shared ssh connection
+func NewClientWithManager(manager *tunnel.Manager, name, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	c.apply(opts...)
+	sock, err := grpcsock.NewClientWithManager(manager, name, localSocket, remoteSocket, c.sockOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CRI socket: %v", err)
+	}
+	c.sock = sock
+	return c, nil
+}
+
+// apply options, the options of grpc connection are collected into sockOpts
+func (c *ClientWithTunnel) apply(opts ...Opt) {
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.log == nil {
+		c.log = &log.NoopLogger{}
+	}
+	c.sockOpts = append(c.sockOpts,
+		grpcsock.WithDialOpts(
+			grpc.WithChainUnaryInterceptor(c.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(c.streamInterceptor)),
+		grpcsock.WithHealthCheck(c.healthCheck))
+}
+
+// APIVersion return the negotiated CRI api version
+func (c *ClientWithTunnel) APIVersion() APIVersion {
+	return c.apiVersion
+}
+
+// Conn return the underlying grpc connection
+func (c *ClientWithTunnel) Conn() *grpc.ClientConn {
+	return c.sock.ClientConn
+}
+
+// Health check whether the container runtime is ready by RuntimeService.Status
+func (c *ClientWithTunnel) Health(ctx context.Context) error {
+	resp, err := c.Status(ctx, &runtimeapi.StatusRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get runtime status: %v", err)
+	}
+	if resp.Status == nil {
+		return fmt.Errorf("runtime status is empty")
+	}
+	for _, condition := range resp.Status.Conditions {
+		if condition.Type == runtimeapi.RuntimeReady && !condition.Status {
+			return fmt.Errorf("runtime is not ready: %s: %s", condition.Reason, condition.Message)
+		}
+	}
+	return nil
+}
+
+// negotiate api version by calling RuntimeService.Version with v1, and fallback to v1alpha2 if it's unimplemented
+func (c *ClientWithTunnel) negotiate(ctx context.Context) (*runtimeapi.VersionResponse, error) {
+	if c.apiVersion != "" {
+		return c.Version(ctx, &runtimeapi.VersionRequest{})
+	}
+
+	for _, version := range []APIVersion{APIVersionV1, APIVersionV1alpha2} {
+		c.apiVersion = version
+		resp, err := c.Version(ctx, &runtimeapi.VersionRequest{})
+		if err == nil {
+			return resp, nil
+		}
+		if status.Code(err) != codes.Unimplemented {
+			c.apiVersion = ""
+			return nil, err
+		}
+		c.log.Debugf("CRI %s is not implemented", version)
+	}
+
+	c.apiVersion = ""
+	return nil, fmt.Errorf("neither CRI v1 nor v1alpha2 is implemented")
+}
+
+// healthCheck negotiate api version and check runtime health, it's run with retry by grpcsock
+func (c *ClientWithTunnel) healthCheck(ctx context.Context, conn *grpc.ClientConn) error {
+	if c.RuntimeServiceClient == nil {
+		c.RuntimeServiceClient = runtimeapi.NewRuntimeServiceClient(conn)
+		c.ImageServiceClient = runtimeapi.NewImageServiceClient(conn)
+	}
+
+	resp, err := c.negotiate(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Health(ctx); err != nil {
+		return err
+	}
+	c.log.Debugf("connected to CRI socket, runtime: %s %s, api: %s",
+		resp.RuntimeName, resp.RuntimeVersion, c.apiVersion)
+	return nil
+}
+
+// method rewrite v1 method to negotiated api version, messages of v1 and v1alpha2 are wire compatible
+func (c *ClientWithTunnel) method(method string) string {
+	if c.apiVersion == APIVersionV1alpha2 {
+		return strings.Replace(method, "/runtime.v1.", "/runtime.v1alpha2.", 1)
+	}
+	return method
+}
+
+func (c *ClientWithTunnel) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(ctx, c.method(method), req, reply, cc, opts...)
+}
+
+func (c *ClientWithTunnel) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(ctx, desc, cc, c.method(method), opts...)
+}
+
+// DoneAndWait close grpc connection, stop tunnel and wait for it to exit
+func (c *ClientWithTunnel) DoneAndWait() {
+	if c.sock != nil {
+		c.sock.DoneAndWait()
+	}
+}
+
+// WithLogger set logger for ClientWithTunnel
+func WithLogger(log log.Logger) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.log = log
+		c.sockOpts = append(c.sockOpts, grpcsock.WithLogger(log))
+		return nil
+	}
+}
+
+// WithAutoRemoveLocalSocket will remove local socket when tunnel exit
+func WithAutoRemoveLocalSocket(c *ClientWithTunnel) error {
+	c.sockOpts = append(c.sockOpts, grpcsock.WithAutoRemoveLocalSocket)
+	return nil
+}
+
+// WithDisableLogger disable all log output
+func WithDisableLogger(c *ClientWithTunnel) error {
+	c.log = &log.NoopLogger{}
+	c.sockOpts = append(c.sockOpts, grpcsock.WithDisableLogger)
+	return nil
+}
+
+// WithDialOpts append grpc dial options
+func WithDialOpts(opts ...grpc.DialOption) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithDialOpts(opts...))
+		return nil
+	}
+}
+
+// WithAPIVersion use the given CRI api version instead of negotiating it
+func WithAPIVersion(version APIVersion) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.apiVersion = version
+		return nil
+	}
+}
+
+// WithPingRetry set max retry for connecting to CRI socket, default is 3
+func WithPingRetry(maxRetry uint) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithPingRetry(maxRetry))
+		return nil
+	}
+}
+
+// WithKeepAlive set keepalive interval and max missed replies of the ssh connection, default is 30s and 3
+func WithKeepAlive(interval time.Duration, maxMissed int) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sockOpts = append(c.sockOpts, grpcsock.WithKeepAlive(interval, maxMissed))
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client is a client of control socket of daemon, it can be used by tools like IDEs and shell prompts
+type Client struct {
+	controlSocket string
+	timeout       time.Duration
+}
+
+// NewClient create a Client of controlSocket
+func NewClient(controlSocket string) *Client {
+	return &Client{controlSocket: controlSocket, timeout: requestTimeout}
+}
+
+// SetTimeout set timeout of a request, default is 30s
+func (c *Client) SetTimeout(timeout time.Duration) *Client {
+	c.timeout = timeout
+	return c
+}
+
+// List return status of all forwards
+func (c *Client) List() ([]ForwardStatus, error) {
+	resp, err := c.Do(Request{Op: OpList})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Forwards, nil
+}
+
+// Status return status of forward name
+func (c *Client) Status(name string) (ForwardStatus, error) {
+	resp, err := c.Do(Request{Op: OpStatus, Name: name})
+	if err != nil {
+		return ForwardStatus{}, err
+	}
+	if len(resp.Forwards) != 1 {
+		return ForwardStatus{}, errors.New("unexpected response of status")
+	}
+	return resp.Forwards[0], nil
+}
+
+// Add add a forward, see Server.Add
+func (c *Client) Add(name, host, remote, local string) (ForwardStatus, error) {
+	resp, err := c.Do(Request{Op: OpAdd, Name: name, Host: host, Remote: remote, Local: local})
+	if err != nil {
+		return ForwardStatus{}, err
+	}
+	if len(resp.Forwards) != 1 {
+		return ForwardStatus{}, errors.New("unexpected response of add")
+	}
+	return resp.Forwards[0], nil
+}
+
+// Remove remove forward name
+func (c *Client) Remove(name string) error {
+	_, err := c.Do(Request{Op: OpRemove, Name: name})
+	return err
+}
+
+// Stop stop the daemon and all its forwards
+func (c *Client) Stop() error {
+	_, err := c.Do(Request{Op: OpStop})
+	return err
+}
+
+// Do send request to control socket, the error of response is returned as error
+func (c *Client) Do(req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", c.controlSocket, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon, is it running? %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	var resp Response
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
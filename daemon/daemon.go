@@ -0,0 +1,368 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// requestTimeout is the timeout of reading a request and writing its response
+const requestTimeout = 30 * time.Second
+
+// HostFactory dial ssh host and return a ClientFactory redialing it, it's called when the first forward of the host is added
+type HostFactory func(host string) (*ssh.Client, tunnel.ClientFactory, error)
+
+// Server keeps forwards to several hosts open and is controlled by a control socket, like ControlMaster of OpenSSH.
+// Forwards of the same host share one ssh connection, which is redialed when it's dead, e.g. after network changes
+type Server struct {
+	controlSocket string
+	hostFactory   HostFactory
+
+	ops      sync.Mutex // serializes Add and Remove, dialing a new host may take a while
+	mu       sync.Mutex // protects maps below, status can be read while a host is being dialed
+	hosts    map[string]*tunnel.Manager
+	forwards map[string]*forward
+	stop     context.CancelFunc
+
+	log log.Logger
+}
+
+// forward is a SocketTunnel registered in the manager of its host
+type forward struct {
+	name   string
+	host   string
+	local  string
+	remote string
+}
+
+// NewServer create a Server listening on controlSocket, hostFactory dials ssh hosts of forwards
+func NewServer(controlSocket string, hostFactory HostFactory) *Server {
+	return &Server{
+		controlSocket: controlSocket,
+		hostFactory:   hostFactory,
+		hosts:         make(map[string]*tunnel.Manager),
+		forwards:      make(map[string]*forward),
+		log:           logrus.New(),
+	}
+}
+
+// SetLogger set custom logger of server and its tunnels
+func (s *Server) SetLogger(logger log.Logger) *Server {
+	s.log = logger
+	return s
+}
+
+// Serve serve control socket until ctx is done or a stop request is received, blocking method.
+// All forwards are removed before it returns
+func (s *Server) Serve(ctx context.Context) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.stop = cancel
+	s.mu.Unlock()
+	defer s.close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	s.log.Infof("daemon is listening on %s", s.controlSocket)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept control connection: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// listen on control socket, a stale socket file is removed but a running daemon is kept
+func (s *Server) listen() (net.Listener, error) {
+	if err := PrepareControlDir(filepath.Dir(s.controlSocket)); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", s.controlSocket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("daemon is already running on %s", s.controlSocket)
+	}
+	if err := os.Remove(s.controlSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale control socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", s.controlSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %v", err)
+	}
+	// only the user can control the daemon
+	if err := os.Chmod(s.controlSocket, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to chmod control socket: %v", err)
+	}
+	return listener, nil
+}
+
+// serveConn handle one request of conn
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req Request
+	resp := Response{}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if len(line) == 0 && errors.Is(err, io.EOF) {
+		// connections probing whether daemon is running
+		return
+	}
+	if err == nil || len(line) > 0 {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else {
+		resp = s.handle(req)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.log.Warnf("failed to write control response: %v", err)
+	}
+}
+
+// handle execute request
+func (s *Server) handle(req Request) Response {
+	var err error
+	switch req.Op {
+	case OpList, OpStatus:
+		forwards := s.List()
+		if req.Name == "" {
+			return Response{Forwards: forwards}
+		}
+		for _, f := range forwards {
+			if f.Name == req.Name {
+				return Response{Forwards: []ForwardStatus{f}}
+			}
+		}
+		err = fmt.Errorf("forward %q doesn't exist", req.Name)
+	case OpAdd:
+		var status ForwardStatus
+		if status, err = s.Add(req.Name, req.Host, req.Remote, req.Local); err == nil {
+			return Response{Forwards: []ForwardStatus{status}}
+		}
+	case OpRemove:
+		err = s.Remove(req.Name)
+	case OpStop:
+		s.mu.Lock()
+		stop := s.stop
+		s.mu.Unlock()
+		stop()
+	default:
+		err = fmt.Errorf("unknown operation %q", req.Op)
+	}
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{}
+}
+
+// Add start a forward from remote socket of host to local socket, name defaults to the host.
+// local defaults to "<name>.sock" next to control socket
+func (s *Server) Add(name, host, remote, local string) (ForwardStatus, error) {
+	if host == "" || remote == "" {
+		return ForwardStatus{}, errors.New("host and remote socket are required")
+	}
+	if name == "" {
+		name = host
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return ForwardStatus{}, fmt.Errorf("invalid name %q", name)
+	}
+	if local == "" {
+		local = filepath.Join(filepath.Dir(s.controlSocket), name+".sock")
+	}
+
+	localAddr, err := tunnel.ParseAddr(local)
+	if err != nil {
+		return ForwardStatus{}, fmt.Errorf("invalid local socket: %v", err)
+	}
+
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	s.mu.Lock()
+	_, exists := s.forwards[name]
+	manager, ok := s.hosts[host]
+	conflict := s.conflict(localAddr)
+	s.mu.Unlock()
+	if exists {
+		return ForwardStatus{}, fmt.Errorf("forward %q already exists", name)
+	}
+	// the local socket is removed before listening, it mustn't be a socket in use
+	if conflict != "" {
+		return ForwardStatus{}, fmt.Errorf("local socket %s is used by %s", localAddr, conflict)
+	}
+	if !ok {
+		client, factory, err := s.hostFactory(host)
+		if err != nil {
+			return ForwardStatus{}, fmt.Errorf("failed to connect to %s: %v", host, err)
+		}
+		manager = tunnel.NewManagerWithClient(client, factory).SetLogger(s.log)
+	}
+
+	socketTunnel := manager.NewSocketTunnel(local, remote).AutoRemoveLocalSocket()
+	if err := manager.Add(name, socketTunnel); err != nil {
+		if !ok {
+			manager.Close()
+		}
+		return ForwardStatus{}, err
+	}
+	f := &forward{name: name, host: host, local: local, remote: remote}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hosts[host] = manager
+	s.forwards[name] = f
+	s.log.Infof("forward %q is added: %s -> %s:%s", name, socketTunnel.LocalAddr(), host, remote)
+	return s.status(f), nil
+}
+
+// conflict return what uses local address, control socket or another forward, s.mu must be held
+func (s *Server) conflict(local tunnel.Addr) string {
+	if control, err := tunnel.ParseAddr(s.controlSocket); err == nil && sameAddr(local, control) {
+		return "control socket"
+	}
+	for _, f := range s.forwards {
+		if addr, err := tunnel.ParseAddr(f.local); err == nil && sameAddr(local, addr) {
+			return fmt.Sprintf("forward %q", f.name)
+		}
+	}
+	return ""
+}
+
+// sameAddr report whether a and b are the same socket, tcp addresses with port 0 never conflict
+func sameAddr(a, b tunnel.Addr) bool {
+	if a.Network != b.Network {
+		return false
+	}
+	if a.Network == "tcp" {
+		if _, port, err := net.SplitHostPort(a.Address); err == nil && port == "0" {
+			return false
+		}
+		return a.Address == b.Address
+	}
+	return filepath.Clean(a.Address) == filepath.Clean(b.Address)
+}
+
+// Remove stop the forward, the ssh connection is closed when the last forward of its host is removed
+func (s *Server) Remove(name string) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	s.mu.Lock()
+	f, ok := s.forwards[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("forward %q doesn't exist", name)
+	}
+	manager := s.hosts[f.host]
+	delete(s.forwards, name)
+	last := true
+	for _, other := range s.forwards {
+		if other.host == f.host {
+			last = false
+		}
+	}
+	if last {
+		delete(s.hosts, f.host)
+	}
+	// stopping the tunnel waits for its connections, status can still be read meanwhile
+	s.mu.Unlock()
+
+	if err := manager.Remove(name); err != nil {
+		s.log.Warnf("failed to remove forward %q: %v", name, err)
+	}
+	if last {
+		manager.Close()
+		s.log.Infof("connection to %s is closed", f.host)
+	}
+	return nil
+}
+
+// List return status of all forwards ordered by name
+func (s *Server) List() []ForwardStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]ForwardStatus, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		statuses = append(statuses, s.status(f))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// status of forward, s.mu must be held
+func (s *Server) status(f *forward) ForwardStatus {
+	manager := s.hosts[f.host]
+	status := ForwardStatus{
+		Name:   f.name,
+		Host:   f.host,
+		Local:  f.local,
+		Remote: f.remote,
+		State:  manager.State().String(),
+	}
+	for _, ts := range manager.Status() {
+		if ts.Name != f.name {
+			continue
+		}
+		status.Local = ts.ListenAddr
+		status.Remote = ts.DialAddr
+		status.Running = ts.Running
+		status.ActiveConnections = ts.ActiveConnections
+		if ts.Err != nil {
+			status.Error = ts.Err.Error()
+		}
+	}
+	return status
+}
+
+// close remove all forwards and the control socket
+func (s *Server) close() {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	s.mu.Lock()
+	hosts := s.hosts
+	s.hosts = make(map[string]*tunnel.Manager)
+	s.forwards = make(map[string]*forward)
+	s.mu.Unlock()
+
+	for _, manager := range hosts {
+		manager.Close()
+	}
+	os.Remove(s.controlSocket)
+	s.log.Infof("daemon is stopped")
+}
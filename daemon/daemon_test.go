@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+func TestAddRejectsSocketInUse(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(filepath.Join(dir, "control.sock"), func(host string) (*ssh.Client, tunnel.ClientFactory, error) {
+		t.Fatalf("host %s is dialed", host)
+		return nil, nil, nil
+	}).SetLogger(&log.NoopLogger{})
+	s.forwards["docker"] = &forward{name: "docker", host: "prod", local: filepath.Join(dir, "docker.sock")}
+
+	cases := []struct {
+		name    string
+		local   string
+		wantErr string
+	}{
+		{name: "control", wantErr: "used by control socket"},
+		{name: "other", local: "unix://" + filepath.Join(dir, "control.sock"), wantErr: "used by control socket"},
+		{name: "other", local: filepath.Join(dir, ".", "docker.sock"), wantErr: `used by forward "docker"`},
+		{name: "other", local: "tcp://", wantErr: "invalid local socket"},
+	}
+	for _, c := range cases {
+		_, err := s.Add(c.name, "staging", "/run/docker.sock", c.local)
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("Add(%q, %q) error = %v, want %q", c.name, c.local, err, c.wantErr)
+		}
+	}
+}
+
+func TestSameAddr(t *testing.T) {
+	cases := []struct {
+		a, b tunnel.Addr
+		want bool
+	}{
+		{tunnel.Addr{Network: "unix", Address: "/tmp/a.sock"}, tunnel.Addr{Network: "unix", Address: "/tmp//a.sock"}, true},
+		{tunnel.Addr{Network: "unix", Address: "/tmp/a.sock"}, tunnel.Addr{Network: "unix", Address: "/tmp/b.sock"}, false},
+		{tunnel.Addr{Network: "tcp", Address: "127.0.0.1:2375"}, tunnel.Addr{Network: "tcp", Address: "127.0.0.1:2375"}, true},
+		{tunnel.Addr{Network: "tcp", Address: "127.0.0.1:0"}, tunnel.Addr{Network: "tcp", Address: "127.0.0.1:0"}, false},
+		{tunnel.Addr{Network: "tcp", Address: "127.0.0.1:2375"}, tunnel.Addr{Network: "unix", Address: "127.0.0.1:2375"}, false},
+	}
+	for _, c := range cases {
+		if got := sameAddr(c.a, c.b); got != c.want {
+			t.Errorf("sameAddr(%s, %s) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
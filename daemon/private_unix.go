@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivate check dir is owned by the current user with mode 0700
+func checkPrivate(dir string, info os.FileInfo) error {
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("mode of %s is %#o, it should be 0700", dir, info.Mode().Perm())
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get owner of %s", dir)
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d instead of the current user", dir, stat.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+package daemon
+
+import "os"
+
+// checkPrivate is a no-op, file modes of windows don't reflect the owner and access
+func checkPrivate(string, os.FileInfo) error {
+	return nil
+}
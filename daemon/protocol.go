@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// operations of control socket
+const (
+	OpList   = "list"
+	OpAdd    = "add"
+	OpRemove = "remove"
+	OpStatus = "status"
+	OpStop   = "stop"
+)
+
+// Request is a request sent to control socket, it's encoded as one line of JSON
+type Request struct {
+	Op     string `json:"op"`
+	Name   string `json:"name,omitempty"`   // name of forward, for add, remove and status
+	Host   string `json:"host,omitempty"`   // ssh host of forward, for add
+	Remote string `json:"remote,omitempty"` // remote socket of forward, for add
+	Local  string `json:"local,omitempty"`  // local socket of forward, for add, default is a socket next to control socket
+}
+
+// Response is the response of Request, it's encoded as one line of JSON
+type Response struct {
+	Error    string          `json:"error,omitempty"`
+	Forwards []ForwardStatus `json:"forwards,omitempty"`
+}
+
+// ForwardStatus is the status of a forward of daemon
+type ForwardStatus struct {
+	Name              string `json:"name"`
+	Host              string `json:"host"`
+	Local             string `json:"local"`  // e.g. "unix:///run/user/1000/sshcontainer/prod.sock"
+	Remote            string `json:"remote"` // e.g. "unix:///var/run/docker.sock"
+	State             string `json:"state"`  // state of the ssh connection, e.g. "connected" or "reconnecting"
+	Running           bool   `json:"running"`
+	ActiveConnections int    `json:"activeConnections"`
+	Error             string `json:"error,omitempty"` // why the forward exited
+}
+
+// PrepareControlDir create dir of control socket and forwarded sockets, or check the existing one.
+// Sockets in it give access to remote container daemons, so it must be owned by the user with mode 0700,
+// otherwise another user could create it in the temp dir first and replace the sockets
+func PrepareControlDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create dir of control socket: %v", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat dir of control socket: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	return checkPrivate(dir, info)
+}
+
+// DefaultControlSocket return $XDG_RUNTIME_DIR/sshcontainer/control.sock,
+// or a socket in the temp dir of the user if XDG_RUNTIME_DIR isn't set
+func DefaultControlSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		return filepath.Join(dir, "sshcontainer", "control.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("sshcontainer-%d", os.Getuid()), "control.sock")
+}
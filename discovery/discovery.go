@@ -0,0 +1,318 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/crypto/ssh"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/aFlyBird0/sshcontainer/containerd"
+	"github.com/aFlyBird0/sshcontainer/cri"
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/internal/shell"
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/podman"
+)
+
+// defaultProbeTimeout is the timeout of getting version of a daemon
+const defaultProbeTimeout = 10 * time.Second
+
+// Kind is the kind of container runtime
+type Kind string
+
+const (
+	KindDocker     Kind = "docker"
+	KindPodman     Kind = "podman"
+	KindContainerd Kind = "containerd"
+	KindCRIO       Kind = "cri-o"
+)
+
+// Capability is an api served on the socket of container runtime
+type Capability string
+
+const (
+	// CapabilityDockerAPI means the socket can be used by docker and podman package
+	CapabilityDockerAPI Capability = "docker-api"
+	// CapabilityContainerdAPI means the socket can be used by containerd package
+	CapabilityContainerdAPI Capability = "containerd-api"
+	// CapabilityCRI means the socket can be used by cri package
+	CapabilityCRI Capability = "cri"
+)
+
+// Candidate is a socket probed by Discover
+type Candidate struct {
+	Kind   Kind
+	Socket string
+}
+
+// DefaultCandidates return well-known sockets of container runtimes in the order of preference,
+// uid is used for sockets of rootless runtimes
+func DefaultCandidates(uid int) []Candidate {
+	candidates := []Candidate{
+		{Kind: KindDocker, Socket: docker.DefaultDockerSock},
+		{Kind: KindDocker, Socket: fmt.Sprintf("/run/user/%d/docker.sock", uid)},
+		{Kind: KindPodman, Socket: podman.DefaultRootfulSocket},
+		{Kind: KindPodman, Socket: podman.RootlessSocket(uid)},
+		{Kind: KindContainerd, Socket: containerd.DefaultContainerdSocket},
+		{Kind: KindCRIO, Socket: cri.DefaultCRIOSocket},
+	}
+	if uid == 0 {
+		// sockets of rootless runtimes are the same as rootful ones
+		return []Candidate{candidates[0], candidates[2], candidates[4], candidates[5]}
+	}
+	return candidates
+}
+
+// Runtime is a container runtime found on remote host
+type Runtime struct {
+	Kind         Kind
+	Socket       string
+	Version      string       // version of the daemon
+	APIVersion   string       // api version of docker api, or CRI api version for containerd and CRI-O
+	Capabilities []Capability // apis served on the socket
+	Accessible   bool         // socket is readable and writable by the ssh user
+	Err          error        // why the runtime can't be used, nil if it's available
+}
+
+// Available return whether the runtime can be used
+func (r Runtime) Available() bool {
+	return r.Err == nil
+}
+
+// Has return whether the runtime serves the api
+func (r Runtime) Has(capability Capability) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Opt is option for Discover
+type Opt func(*discoverer) error
+
+type discoverer struct {
+	sshClient  *ssh.Client
+	candidates []Candidate
+	log        log.Logger
+}
+
+// Discover probe sockets of container runtimes on the remote host of sshClient.
+// It returns all runtimes whose socket exists, available ones first, in the order of candidates,
+// see DefaultCandidates
+func Discover(sshClient *ssh.Client, opts ...Opt) ([]Runtime, error) {
+	d := &discoverer{sshClient: sshClient}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.log == nil {
+		d.log = &log.NoopLogger{}
+	}
+	if d.candidates == nil {
+		uid, err := podman.RemoteUID(sshClient)
+		if err != nil {
+			return nil, err
+		}
+		d.candidates = DefaultCandidates(uid)
+	}
+
+	runtimes, err := d.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	for i := range runtimes {
+		if runtimes[i].Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *Runtime) {
+			defer wg.Done()
+			d.probe(r)
+		}(&runtimes[i])
+	}
+	wg.Wait()
+
+	sort.SliceStable(runtimes, func(i, j int) bool {
+		return runtimes[i].Available() && !runtimes[j].Available()
+	})
+	return runtimes, nil
+}
+
+// Best return the first available runtime serving the api, it returns false if there is none
+func Best(runtimes []Runtime, capability Capability) (Runtime, bool) {
+	for _, r := range runtimes {
+		if r.Available() && r.Has(capability) {
+			return r, true
+		}
+	}
+	return Runtime{}, false
+}
+
+// stat check existence and permission of all candidates in one ssh session,
+// it returns runtimes whose socket exists in the order of candidates
+func (d *discoverer) stat() ([]Runtime, error) {
+	var script strings.Builder
+	for i, c := range d.candidates {
+		fmt.Fprintf(&script, "if [ -S %[2]s ]; then if [ -r %[2]s ] && [ -w %[2]s ]; then echo %[1]d 1; else echo %[1]d 0; fi; fi\n",
+			i, shell.Quote(c.Socket))
+	}
+
+	session, err := d.sshClient.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh session: %v", err)
+	}
+	defer session.Close()
+	out, err := session.Output(script.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat sockets on remote host: %v", err)
+	}
+
+	var runtimes []Runtime
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var i, accessible int
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &i, &accessible); err != nil || i < 0 || i >= len(d.candidates) {
+			return nil, fmt.Errorf("unexpected output of stat sockets: %q", scanner.Text())
+		}
+		r := Runtime{
+			Kind:       d.candidates[i].Kind,
+			Socket:     d.candidates[i].Socket,
+			Accessible: accessible == 1,
+		}
+		if !r.Accessible {
+			r.Err = fmt.Errorf("permission denied")
+		}
+		runtimes = append(runtimes, r)
+	}
+	return runtimes, nil
+}
+
+// probe get version and capabilities of the runtime
+func (d *discoverer) probe(r *Runtime) {
+	switch r.Kind {
+	case KindDocker, KindPodman:
+		r.Err = d.probeDockerAPI(r)
+	case KindContainerd:
+		r.Err = d.probeContainerd(r)
+		if r.Err == nil {
+			// CRI plugin of containerd may be disabled
+			if d.probeCRI(r) == nil {
+				r.Capabilities = append(r.Capabilities, CapabilityCRI)
+			}
+		}
+	case KindCRIO:
+		r.Err = d.probeCRI(r)
+		if r.Err == nil {
+			r.Capabilities = append(r.Capabilities, CapabilityCRI)
+		}
+	default:
+		r.Err = fmt.Errorf("unknown runtime kind %q", r.Kind)
+	}
+	if r.Err != nil {
+		d.log.Debugf("%s socket %s is unavailable: %v", r.Kind, r.Socket, r.Err)
+	}
+}
+
+// probeDockerAPI get version of docker or podman, the kind is corrected by the components of version,
+// e.g. docker.sock is a symlink of podman.sock
+func (d *discoverer) probeDockerAPI(r *Runtime) error {
+	cli, err := docker.NewClientWithTunnel(d.sshClient, "", r.Socket,
+		docker.WithLogger(d.log),
+		docker.WithPingRetry(1),
+		docker.WithDockerClientOpts(client.WithAPIVersionNegotiation()))
+	if err != nil {
+		return err
+	}
+	defer cli.DoneAndWait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProbeTimeout)
+	defer cancel()
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get version: %v", err)
+	}
+
+	r.Kind = KindDocker
+	for _, component := range version.Components {
+		if strings.Contains(component.Name, "Podman") {
+			r.Kind = KindPodman
+		}
+	}
+	r.Version = version.Version
+	r.APIVersion = version.APIVersion
+	r.Capabilities = append(r.Capabilities, CapabilityDockerAPI)
+	return nil
+}
+
+// probeContainerd get version of containerd
+func (d *discoverer) probeContainerd(r *Runtime) error {
+	cli, err := containerd.NewClientWithTunnel(d.sshClient, "", r.Socket,
+		containerd.WithLogger(d.log),
+		containerd.WithPingRetry(1))
+	if err != nil {
+		return err
+	}
+	defer cli.DoneAndWait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProbeTimeout)
+	defer cancel()
+	version, err := cli.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get version: %v", err)
+	}
+
+	r.Version = version.Version
+	r.Capabilities = append(r.Capabilities, CapabilityContainerdAPI)
+	return nil
+}
+
+// probeCRI get CRI api version, and runtime version if the runtime doesn't have a version yet
+func (d *discoverer) probeCRI(r *Runtime) error {
+	cli, err := cri.NewClientWithTunnel(d.sshClient, "", r.Socket,
+		cri.WithLogger(d.log),
+		cri.WithPingRetry(1))
+	if err != nil {
+		return err
+	}
+	defer cli.DoneAndWait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProbeTimeout)
+	defer cancel()
+	version, err := cli.Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get version: %v", err)
+	}
+
+	if r.Version == "" {
+		r.Version = version.RuntimeVersion
+	}
+	r.APIVersion = string(cli.APIVersion())
+	return nil
+}
+
+// WithCandidates probe the given sockets instead of DefaultCandidates
+func WithCandidates(candidates ...Candidate) Opt {
+	return func(d *discoverer) error {
+		d.candidates = candidates
+		return nil
+	}
+}
+
+// WithLogger set logger for Discover
+func WithLogger(log log.Logger) Opt {
+	return func(d *discoverer) error {
+		d.log = log
+		return nil
+	}
+}
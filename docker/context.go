@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultContext is the context of docker cli using DOCKER_HOST or the default socket
+const defaultContext = "default"
+
+// dockerConfigDir return DOCKER_CONFIG or ~/.docker
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home dir: %v", err)
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// ContextHost return the docker host of docker context name, e.g. "ssh://user@host".
+// If name is empty, it's resolved like docker cli: DOCKER_HOST, DOCKER_CONTEXT,
+// currentContext of ~/.docker/config.json, then the default context
+func ContextHost(name string) (string, error) {
+	if name == "" {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return host, nil
+		}
+		current, err := currentContext()
+		if err != nil {
+			return "", err
+		}
+		name = current
+	}
+	if name == defaultContext {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return host, nil
+		}
+		return "unix://" + DefaultDockerSock, nil
+	}
+
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	// metadata of context is stored in a dir named by sha256 of context name
+	digest := sha256.Sum256([]byte(name))
+	metaFile := filepath.Join(dir, "contexts", "meta", hex.EncodeToString(digest[:]), "meta.json")
+	data, err := os.ReadFile(metaFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("docker context %q is not found", name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read docker context: %v", err)
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host string
+		}
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", fmt.Errorf("failed to parse docker context %q: %v", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return "", fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+	return endpoint.Host, nil
+}
+
+// currentContext return DOCKER_CONTEXT, currentContext of config.json, or the default context
+func currentContext() (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultContext, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read docker config: %v", err)
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("failed to parse docker config: %v", err)
+	}
+	if config.CurrentContext == "" {
+		return defaultContext, nil
+	}
+	return config.CurrentContext, nil
+}
@@ -9,17 +9,26 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/sshclient"
 	"github.com/aFlyBird0/sshcontainer/tunnel"
 )
 
 const DefaultDockerSock = "/var/run/docker.sock"
 
+// pingTimeout is the timeout of one ping, dialing the ssh connection is included
+const pingTimeout = 30 * time.Second
+
 // ClientWithTunnel is docker client with tunnel
 type ClientWithTunnel struct {
 	*client.Client
 	dockerOpts []client.Opt
 
 	socketTunnel *tunnel.SocketTunnel
+	dialer       *tunnel.Dialer
+	manager      *tunnel.Manager // nil if tunnel is not registered in manager
+	name         string          // name of tunnel in manager
+
+	sshOpts []sshclient.Opt // options of ssh client dialed by the client itself
 
 	maxRetry uint
 	log      log.Logger
@@ -28,41 +37,77 @@ type ClientWithTunnel struct {
 // Opt is option for ClientWithTunnel
 type Opt func(*ClientWithTunnel) error
 
-// NewClientWithTunnel create docker client with tunnel
+// NewClientWithTunnel create docker client with tunnel, localSocket and remoteSocket can be
+// "unix:///path/to/socket", "tcp://host:port" or a unix socket path without scheme.
+// If localSocket is empty, no local socket file is created and docker client dials remote socket over ssh directly
 func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
-	tunnel := tunnel.NewSocketTunnel(localSocket, remoteSocket, sshClient)
+	c := &ClientWithTunnel{}
+	if localSocket == "" {
+		c.dialer = tunnel.NewDialer(remoteSocket, sshClient)
+	} else {
+		c.socketTunnel = tunnel.NewSocketTunnel(localSocket, remoteSocket, sshClient)
+	}
+	return c.connect(remoteSocket, opts...)
+}
+
+// NewClientWithManager create docker client whose tunnel shares the ssh connection of manager,
+// the tunnel is registered in manager with name, and removed from manager by DoneAndWait.
+// If localSocket is empty, no tunnel is registered and docker client dials remote socket over the shared ssh connection
+func NewClientWithManager(manager *tunnel.Manager, name, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
 	c := &ClientWithTunnel{
-		socketTunnel: tunnel,
+		manager: manager,
+		name:    name,
 	}
+	if localSocket == "" {
+		c.dialer = manager.NewDialer(remoteSocket)
+	} else {
+		c.socketTunnel = manager.NewSocketTunnel(localSocket, remoteSocket)
+	}
+	return c.connect(remoteSocket, opts...)
+}
+
+// connect apply options, start tunnel and connect to docker socket
+func (c *ClientWithTunnel) connect(remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
 	for _, opt := range opts {
 		opt(c)
 	}
 	if c.log == nil {
 		c.log = &log.NoopLogger{}
 	}
-	c.socketTunnel.SetLogger(c.log)
 	if c.maxRetry == 0 {
 		c.maxRetry = 3
 	}
 
-	go func() {
-		if err := tunnel.Start(); err != nil {
-			c.log.Errorf("failed to start docker socket tunnel: %v", err)
-			// no need to exist because docker client will retry and report error
+	if c.dialer != nil {
+		remote, err := tunnel.ParseAddr(remoteSocket)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote socket: %v", err)
+		}
+		// the host is only used to build request url, all connections are dialed by dialer
+		c.dockerOpts = append(c.dockerOpts,
+			client.WithHost(remote.String()),
+			client.WithDialContext(c.dialer.DialContext))
+	} else {
+		c.socketTunnel.SetLogger(c.log)
+		if err := c.startTunnel(); err != nil {
+			return nil, err
 		}
-	}()
 
-	dockerHost := "unix://" + localSocket
-	c.dockerOpts = append(c.dockerOpts, client.WithHost(dockerHost))
+		// use the listening address, so that tcp port 0 works
+		dockerHost := c.socketTunnel.LocalAddr().String()
+		c.dockerOpts = append(c.dockerOpts, client.WithHost(dockerHost))
+	}
 
 	cli, err := client.NewClientWithOpts(c.dockerOpts...)
 	if err != nil {
+		c.DoneAndWait()
 		return nil, fmt.Errorf("failed to create docker client: %v", err)
 	}
 	c.Client = cli
 
 	// try to connect to docker socket
 	if err := c.pingWithRetry(); err != nil {
+		c.DoneAndWait()
 		return nil, err
 	}
 
@@ -71,25 +116,70 @@ func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string
 
 // PingWithRetry try to ping docker socket with retry to make sure it's ready
 func (c *ClientWithTunnel) pingWithRetry() error {
+	var err error
 	for i := uint(0); i < c.maxRetry; i++ {
 		if i != 0 {
 			time.Sleep(1 * time.Second)
 		}
 
-		if _, err := c.Ping(context.Background()); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		_, err = c.Ping(ctx)
+		cancel()
+		if err == nil {
 			c.log.Debugf("connected to docker socket")
 			return nil
 		}
 
-		c.log.Debugf("failed to connect to docker socket, retrying...")
+		c.log.Debugf("failed to connect to docker socket, retrying...: %v", err)
+	}
+
+	return fmt.Errorf("failed to connect to docker socket: %v", err)
+}
+
+// startTunnel start socket tunnel in background, and log its error after it exits
+func (c *ClientWithTunnel) startTunnel() error {
+	if c.manager != nil {
+		if err := c.manager.Add(c.name, c.socketTunnel); err != nil {
+			return fmt.Errorf("failed to start docker socket tunnel: %v", err)
+		}
+		return nil
+	}
+
+	errc, err := c.socketTunnel.Start(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to start docker socket tunnel: %v", err)
 	}
+	go func() {
+		if err := <-errc; err != nil {
+			c.log.Errorf("docker socket tunnel exited: %v", err)
+		}
+	}()
+	return nil
+}
 
-	return fmt.Errorf("failed to connect to docker socket")
+// LocalAddr return the listening address of local socket, e.g. the port of "tcp://127.0.0.1:0" is resolved,
+// ok is false if there is no local socket
+func (c *ClientWithTunnel) LocalAddr() (addr tunnel.Addr, ok bool) {
+	if c.socketTunnel == nil {
+		return tunnel.Addr{}, false
+	}
+	return c.socketTunnel.LocalAddr(), true
 }
 
 // DoneAndWait stop tunnel and wait for all connections closed
 func (c *ClientWithTunnel) DoneAndWait() {
-	c.socketTunnel.Stop()
+	if c.socketTunnel != nil {
+		if c.manager != nil {
+			if err := c.manager.Remove(c.name); err != nil {
+				c.log.Errorf("failed to remove docker socket tunnel: %v", err)
+			}
+		} else {
+			c.socketTunnel.Stop()
+		}
+	}
+	if c.dialer != nil {
+		c.dialer.Close()
+	}
 }
 
 // WithLogger set custom logger
@@ -102,7 +192,9 @@ func WithLogger(log log.Logger) Opt {
 
 // WithAutoRemoveLocalSocket remove local socket file before and after tunnel
 func WithAutoRemoveLocalSocket(c *ClientWithTunnel) error {
-	c.socketTunnel.AutoRemoveLocalSocket()
+	if c.socketTunnel != nil {
+		c.socketTunnel.AutoRemoveLocalSocket()
+	}
 	return nil
 }
 
@@ -127,3 +219,16 @@ func WithPingRetry(maxRetry uint) Opt {
 		return nil
 	}
 }
+
+// WithKeepAlive set keepalive interval and max missed replies of the ssh connection, default is 30s and 3
+func WithKeepAlive(interval time.Duration, maxMissed int) Opt {
+	return func(c *ClientWithTunnel) error {
+		if c.socketTunnel != nil {
+			c.socketTunnel.SetKeepAlive(interval, maxMissed)
+		}
+		if c.dialer != nil {
+			c.dialer.SetKeepAlive(interval, maxMissed)
+		}
+		return nil
+	}
+}
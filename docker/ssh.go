@@ -0,0 +1,136 @@
+package docker
+
+import (
+	"fmt"
+	"net/url"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/sshclient"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+// SSHHost is a docker host over ssh, like "ssh://user@host:port" of DOCKER_HOST
+type SSHHost struct {
+	User   string // empty to use User of ssh config or the local user
+	Host   string // host name or alias of ssh config
+	Port   string // empty to use Port of ssh config or 22
+	Socket string // remote docker socket, it's the path of url or DefaultDockerSock
+}
+
+// ParseSSHHost parse docker host like "ssh://user@host:port",
+// the path of url is used as remote docker socket, e.g. "ssh://user@host/run/user/1000/docker.sock"
+func ParseSSHHost(rawURL string) (*SSHHost, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %v", rawURL, err)
+	}
+	if u.Scheme != "ssh" {
+		return nil, fmt.Errorf("invalid docker host %q: scheme should be ssh", rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid docker host %q: no host", rawURL)
+	}
+	h := &SSHHost{
+		Host:   u.Hostname(),
+		Port:   u.Port(),
+		Socket: DefaultDockerSock,
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return nil, fmt.Errorf("invalid docker host %q: password is not allowed in url", rawURL)
+		}
+		h.User = u.User.Username()
+	}
+	if u.Path != "" && u.Path != "/" {
+		h.Socket = u.Path
+	}
+	return h, nil
+}
+
+// Dial connect to the ssh host, ~/.ssh/config of the host is used like docker cli which runs ssh command,
+// user and port of url take precedence. opts are applied after options of ssh config
+func (h *SSHHost) Dial(opts ...sshclient.Opt) (*ssh.Client, error) {
+	hostConfig, err := sshclient.LoadHostConfig(h.Host)
+	if err != nil {
+		return nil, err
+	}
+	if h.User != "" {
+		hostConfig.User = h.User
+	}
+	if h.Port != "" {
+		hostConfig.Port = h.Port
+	}
+	hostOpts, err := hostConfig.Opts()
+	if err != nil {
+		return nil, err
+	}
+	return sshclient.Dial(hostConfig.Addr(), hostConfig.User, append(hostOpts, opts...)...)
+}
+
+// NewClientWithFactory create docker client whose ssh client is dialed by factory,
+// the ssh client is redialed when it's dead and closed by DoneAndWait.
+// The first ssh client is dialed before creating docker client, so errors like rejected host keys are returned
+func NewClientWithFactory(factory tunnel.ClientFactory, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	sshClient, err := factory()
+	if err != nil {
+		return nil, err
+	}
+	c := &ClientWithTunnel{}
+	if localSocket == "" {
+		c.dialer = tunnel.NewDialerWithClient(remoteSocket, sshClient, factory)
+	} else {
+		c.socketTunnel = tunnel.NewSocketTunnelWithClient(localSocket, remoteSocket, sshClient, factory)
+	}
+	c, err = c.connect(remoteSocket, opts...)
+	if err != nil {
+		// the tunnel may fail before it's started, e.g. invalid remote socket
+		sshClient.Close()
+	}
+	return c, err
+}
+
+// NewClientFromSSHURL create docker client of docker host like "ssh://user@host:port", see ParseSSHHost.
+// Options of ssh client are set by WithSSHOpts
+func NewClientFromSSHURL(rawURL, localSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	host, err := ParseSSHHost(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return newClientWithSSHHost(host, localSocket, opts...)
+}
+
+// NewClientFromContext create docker client of docker context name like `docker --context name`,
+// the current context of docker cli is used if name is empty, see ContextHost.
+// Only contexts of ssh hosts are supported, options of ssh client are set by WithSSHOpts
+func NewClientFromContext(name, localSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	dockerHost, err := ContextHost(name)
+	if err != nil {
+		return nil, err
+	}
+	host, err := ParseSSHHost(dockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("docker context %q isn't an ssh host: %v", name, err)
+	}
+	return newClientWithSSHHost(host, localSocket, opts...)
+}
+
+func newClientWithSSHHost(host *SSHHost, localSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	// options of ssh client are needed before connect applies opts
+	applied := &ClientWithTunnel{}
+	for _, opt := range opts {
+		opt(applied)
+	}
+	return NewClientWithFactory(func() (*ssh.Client, error) {
+		return host.Dial(applied.sshOpts...)
+	}, localSocket, host.Socket, opts...)
+}
+
+// WithSSHOpts set options of ssh client dialed by NewClientFromSSHURL and NewClientFromContext,
+// e.g. sshclient.WithPassword
+func WithSSHOpts(opts ...sshclient.Opt) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.sshOpts = opts
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/moby/buildkit/client"
+	"github.com/sirupsen/logrus"
+
+	"github.com/aFlyBird0/sshcontainer/buildkit"
+	"github.com/aFlyBird0/sshcontainer/examples/util"
+)
+
+func main() {
+	// get ssh client
+	const (
+		hostPort = "1.2.3.4:22"
+		user     = "root"
+		pwd      = "xxx"         // pwd or key
+		keyFile  = "path/to/key" // pwd or key
+	)
+	// the type of sshClient is *ssh.Client
+	sshClient := util.CreateSSHClient(hostPort, user, pwd, keyFile)
+
+	logrus.Infof("start to create buildkit client")
+
+	// empty local socket: dial remote socket over ssh directly
+	localSocket := ""
+	remoteSocket := buildkit.DefaultBuildkitdSocket
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	buildkitClient, err := buildkit.NewClientWithTunnel(sshClient, localSocket, remoteSocket,
+		buildkit.WithLogger(logger),
+		buildkit.WithPingRetry(10),
+		// like `--secret id=npmrc,src=$HOME/.npmrc`
+		buildkit.WithSecrets(map[string]string{"npmrc": "/root/.npmrc"}),
+		// like `--ssh default`, forward local $SSH_AUTH_SOCK
+		buildkit.WithSSHAgent("default"))
+	if err != nil {
+		logrus.Fatalf("Failed to create buildkit client: %v\n", err)
+	}
+
+	// declare that ssh connection is no longer in use
+	defer buildkitClient.DoneAndWait()
+
+	// business logic of buildkit client, like `buildctl build --frontend dockerfile.v0 --local context=. --local dockerfile=.`
+	if err := build(buildkitClient); err != nil {
+		logrus.Errorf(err.Error() + "\n")
+	}
+}
+
+func build(c *buildkit.ClientWithTunnel) error {
+	statusChan := make(chan *client.SolveStatus)
+	go func() {
+		for status := range statusChan {
+			for _, vertex := range status.Vertexes {
+				logrus.Infof("vertex: %s", vertex.Name)
+			}
+		}
+	}()
+
+	// local build context is synced to buildkitd over the ssh connection
+	_, err := c.Solve(context.Background(), nil, client.SolveOpt{
+		Frontend: "dockerfile.v0",
+		LocalDirs: map[string]string{
+			"context":    ".",
+			"dockerfile": ".",
+		},
+	}, statusChan)
+	return err
+}
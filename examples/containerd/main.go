@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"time"
 
 	ctrd "github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
@@ -39,10 +38,9 @@ func main() {
 		containerd.WithAutoRemoveLocalSocket,
 		containerd.WithLogger(logger),
 		containerd.WithPingRetry(10),
-		containerd.WithContainerdClientOpts(
-			ctrd.WithTimeout(10*time.Second),
-			// todo namespace
-			ctrd.WithDefaultNamespace("k8s.io")))
+		// requests without namespace use "k8s.io", or use containerd.WithNamespaceDiscovery()
+		// to pick one of "k8s.io", "moby" and "default" existing on the remote host
+		containerd.WithNamespace("k8s.io"))
 
 	if err != nil {
 		logrus.Errorf("Failed to create containerd client: %v\n", err)
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/aFlyBird0/sshcontainer/cri"
+	"github.com/aFlyBird0/sshcontainer/examples/util"
+)
+
+func main() {
+	// get ssh client
+	const (
+		hostPort = "1.2.3.4:22"
+		user     = "root"
+		pwd      = "xxx"         // pwd or key
+		keyFile  = "path/to/key" // pwd or key
+	)
+	// the type of sshClient is *ssh.Client
+	sshClient := util.CreateSSHClient(hostPort, user, pwd, keyFile)
+
+	logrus.Infof("start to create CRI client")
+
+	// empty local socket: dial remote socket over ssh directly, use cri.DefaultCRIOSocket for CRI-O
+	localSocket := ""
+	remoteSocket := cri.DefaultContainerdSocket
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	criClient, err := cri.NewClientWithTunnel(sshClient, localSocket, remoteSocket,
+		cri.WithLogger(logger),
+		cri.WithPingRetry(10))
+	if err != nil {
+		logrus.Fatalf("Failed to create CRI client: %v\n", err)
+	}
+
+	// declare that ssh connection is no longer in use
+	defer criClient.DoneAndWait()
+
+	// business logic of CRI client, like `crictl ps` and `crictl images`
+	if err := doSomeOperations(criClient); err != nil {
+		logrus.Errorf(err.Error() + "\n")
+	}
+}
+
+func doSomeOperations(client *cri.ClientWithTunnel) error {
+	ctx := context.Background()
+
+	version, err := client.Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		return fmt.Errorf("Unable to get version: %v", err)
+	}
+	logrus.Infof("runtime: %s %s, CRI api: %s", version.RuntimeName, version.RuntimeVersion, client.APIVersion())
+
+	containers, err := client.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return fmt.Errorf("Unable to list containers: %v", err)
+	}
+	for _, container := range containers.Containers {
+		logrus.Infof("container id: %s, name: %s, state: %s", container.Id, container.Metadata.GetName(), container.State)
+	}
+
+	images, err := client.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if err != nil {
+		return fmt.Errorf("Unable to list images: %v", err)
+	}
+	for _, image := range images.Images {
+		logrus.Infof("image id: %s, repo tags: %v", image.Id, image.RepoTags)
+	}
+
+	return nil
+}
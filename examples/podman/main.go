@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/examples/util"
+	"github.com/aFlyBird0/sshcontainer/podman"
+)
+
+func main() {
+	// get ssh client
+	const (
+		hostPort = "1.2.3.4:22"
+		user     = "root"
+		pwd      = "xxx"         // pwd or key
+		keyFile  = "path/to/key" // pwd or key
+	)
+	// the type of sshClient is *ssh.Client
+	sshClient := util.CreateSSHClient(hostPort, user, pwd, keyFile)
+
+	logrus.Infof("start to create podman client")
+
+	// create a temporary podman socket file on local
+	localSocket := "./.sock/podman.sock"
+	// empty remote socket means detecting rootful or rootless socket by the uid of remote user
+	remoteSocket := ""
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	// create podman client with socket tunnel, options of docker package can be used
+	podmanClient, err := podman.NewClientWithTunnel(sshClient, localSocket, remoteSocket,
+		docker.WithAutoRemoveLocalSocket,
+		docker.WithLogger(logger),
+		docker.WithPingRetry(10),
+		docker.WithDockerClientOpts(
+			client.WithTimeout(10*time.Second),
+			client.WithAPIVersionNegotiation(),
+		),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create podman client: %v", err)
+	}
+
+	// declare that socket tunnel is no longer in use, automatically clear socket, close socket tunnel
+	defer podmanClient.DoneAndWait()
+
+	logrus.Infof("remote socket: %s, podman bindings uri: %s", podmanClient.RemoteSocket(), podmanClient.URI())
+
+	// business logic with docker compatible api,
+	// or create a podman bindings connection by podmanClient.Connection(ctx, bindings.NewConnection)
+	containers, err := podmanClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		logrus.Errorf("unable to list containers: %v", err)
+		return
+	}
+	for _, container := range containers {
+		logrus.Infof("container id: %s, name: %s", container.ID, container.Names)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+
+	"github.com/aFlyBird0/sshcontainer/docker"
+	"github.com/aFlyBird0/sshcontainer/sshclient"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+func main() {
+
+	// the host alias is defined in ~/.ssh/config, e.g.
+	//
+	//	Host prod-docker-*
+	//	    User deploy
+	//	    ProxyJump bastion
+	//	Host prod-docker-3
+	//	    HostName 10.0.0.3
+	const alias = "prod-docker-3"
+
+	hostConfig, err := sshclient.LoadHostConfig(alias)
+	if err != nil {
+		logrus.Fatalf("failed to load ssh config: %v", err)
+	}
+	logrus.Infof("%s is %s@%s, jump hosts: %v", alias, hostConfig.User, hostConfig.Addr(), hostConfig.ProxyJump)
+
+	// the ssh connection is dialed from ssh config like `ssh prod-docker-3`,
+	// and redialed with the latest ssh config when it's dead
+	manager := tunnel.NewManagerWithFactory(sshclient.HostFactory(alias))
+	defer manager.Close()
+
+	dockerClient, err := docker.NewClientWithManager(manager, "docker", "", docker.DefaultDockerSock,
+		docker.WithDockerClientOpts(client.WithAPIVersionNegotiation()),
+	)
+	if err != nil {
+		logrus.Fatalf("failed to create docker client: %v", err)
+	}
+	defer dockerClient.DoneAndWait()
+
+	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		logrus.Fatalf("failed to list containers: %v", err)
+	}
+	for _, container := range containers {
+		logrus.Infof("container id: %s, name: %s", container.ID, container.Names)
+	}
+}
@@ -33,11 +33,14 @@ func main() {
 	socketTunnel := tunnel.NewSocketTunnel(localSocket, remoteSocket, sshClient).
 		AutoRemoveLocalSocket().SetLogger(logger)
 
-	// start tunnel
+	// start tunnel, the local socket is listening after Start returns
+	errc, err := socketTunnel.Start(context.Background())
+	if err != nil {
+		logger.Fatalf("failed to start socket tunnel: %v", err)
+	}
 	go func() {
-		if err := socketTunnel.Start(); err != nil {
-			logger.Errorf("failed to start docker socket socketTunnel: %v", err)
-			// no need to exist because docker client will retry and report error
+		if err := <-errc; err != nil {
+			logger.Errorf("socket tunnel exited: %v", err)
 		}
 	}()
 
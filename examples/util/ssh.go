@@ -2,51 +2,39 @@ package util
 
 import (
 	"fmt"
-	"log"
 	"os"
-	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/sshclient"
 )
 
-// CreateSSHClient create ssh client
+// CreateSSHClient create ssh client, see sshclient package for more auth methods and host key verification
 func CreateSSHClient(hostPort, user, pwd, keyFile string) *ssh.Client {
 	logrus.Infof("start to dial ssh")
-	var auths []ssh.AuthMethod
+	// host key is verified by ~/.ssh/known_hosts, agent is skipped if it isn't running
+	opts := []sshclient.Opt{sshclient.WithAgent()}
 	if keyFile != "" {
-		if keyAuth, err := authenticationKey(keyFile); err == nil {
-			auths = append(auths, keyAuth)
+		if signer, err := loadKey(keyFile); err == nil {
+			opts = append(opts, sshclient.WithSigners(signer))
 		} else {
 			logrus.Warnf("unable to use key file (%s): %v", keyFile, err)
 		}
 	}
 	if pwd != "" {
-		auths = append(auths, ssh.Password(pwd))
-	}
-
-	if len(auths) == 0 {
-		logrus.Fatalf("no authentication method")
+		opts = append(opts, sshclient.WithPassword(pwd))
 	}
 
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // ignore host key check
-		Timeout:         10 * time.Second,
-	}
-
-	// connect to ssh
-	sshClient, err := ssh.Dial("tcp", hostPort, config)
+	sshClient, err := sshclient.Dial(hostPort, user, opts...)
 	if err != nil {
-		log.Fatalf("Failed to dial SSH: %v", err)
+		logrus.Fatalf("failed to create ssh client: %v", err)
 	}
-
 	return sshClient
 }
 
 // get private key from private key file path
-func authenticationKey(privateKeyPath string) (ssh.AuthMethod, error) {
+func loadKey(privateKeyPath string) (ssh.Signer, error) {
 	privateKeyBytes, err := os.ReadFile(privateKeyPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read private key: %v", err)
@@ -56,6 +44,5 @@ func authenticationKey(privateKeyPath string) (ssh.AuthMethod, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %v", err)
 	}
-
-	return ssh.PublicKeys(privateKey), nil
+	return privateKey, nil
 }
@@ -5,7 +5,9 @@ go 1.16
 require (
 	github.com/containerd/containerd v1.7.1
 	github.com/docker/docker v24.0.0+incompatible
+	github.com/moby/buildkit v0.11.6
 	github.com/sirupsen/logrus v1.9.2
 	golang.org/x/crypto v0.9.0
 	google.golang.org/grpc v1.55.0
+	k8s.io/cri-api v0.27.1
 )
@@ -0,0 +1,291 @@
+package grpcsock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+const (
+	// defaultDialTimeout is the timeout of grpc dial and every health check, same as containerd default
+	defaultDialTimeout = 10 * time.Second
+	// same as containerd and kubelet
+	defaultMaxMsgSize = 16 << 20
+)
+
+// HealthCheck checks whether the daemon serving conn is ready
+type HealthCheck func(ctx context.Context, conn *grpc.ClientConn) error
+
+// GRPCHealthCheck return a HealthCheck using the standard grpc health checking protocol,
+// empty service checks the overall health of the server
+func GRPCHealthCheck(service string) HealthCheck {
+	return func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx,
+			&grpc_health_v1.HealthCheckRequest{Service: service}, grpc.WaitForReady(true))
+		if err != nil {
+			return err
+		}
+		if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			return fmt.Errorf("service is %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// ClientWithTunnel is grpc connection to a daemon listening on remote socket, the connection is dialed over ssh
+type ClientWithTunnel struct {
+	*grpc.ClientConn
+	dialOpts    []grpc.DialOption
+	healthCheck HealthCheck
+
+	socketTunnel *tunnel.SocketTunnel
+	dialer       *tunnel.Dialer
+	manager      *tunnel.Manager // nil if tunnel is not registered in manager
+	name         string          // name of tunnel in manager
+	target       string          // target of grpc dial, it's only used as authority
+	contextDial  func(ctx context.Context, addr string) (net.Conn, error)
+
+	maxRetry uint
+	log      log.Logger
+}
+
+// Opt is option for ClientWithTunnel
+type Opt func(*ClientWithTunnel) error
+
+// NewClientWithTunnel create grpc connection with tunnel, localSocket and remoteSocket can be
+// "unix:///path/to/socket", "tcp://host:port" or a unix socket path without scheme.
+// If localSocket is empty, no local socket file is created and grpc connection is dialed over ssh directly
+func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{}
+	if localSocket == "" {
+		c.dialer = tunnel.NewDialer(remoteSocket, sshClient)
+	} else {
+		c.socketTunnel = tunnel.NewSocketTunnel(localSocket, remoteSocket, sshClient)
+	}
+	return c.connect(opts...)
+}
+
+// NewClientWithManager create grpc connection whose tunnel shares the ssh connection of manager,
+// the tunnel is registered in manager with name, and removed from manager by DoneAndWait.
+// If localSocket is empty, no tunnel is registered and grpc connection is dialed over the shared ssh connection
+func NewClientWithManager(manager *tunnel.Manager, name, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	c := &ClientWithTunnel{
+		manager: manager,
+		name:    name,
+	}
+	if localSocket == "" {
+		c.dialer = manager.NewDialer(remoteSocket)
+	} else {
+		c.socketTunnel = manager.NewSocketTunnel(localSocket, remoteSocket)
+	}
+	return c.connect(opts...)
+}
+
+// connect apply options, start tunnel and dial grpc connection
+func (c *ClientWithTunnel) connect(opts ...Opt) (*ClientWithTunnel, error) {
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.log == nil {
+		c.log = &log.NoopLogger{}
+	}
+	if c.maxRetry == 0 {
+		c.maxRetry = 3
+	}
+	if c.healthCheck == nil {
+		c.healthCheck = GRPCHealthCheck("")
+	}
+
+	// the target is only used as authority, all connections are dialed by contextDial
+	if c.dialer != nil {
+		c.target = "passthrough:///" + c.dialer.RemoteAddr().Address
+		c.contextDial = c.dialer.ContextDialer
+	} else {
+		c.socketTunnel.SetLogger(c.log)
+		if err := c.startTunnel(); err != nil {
+			return nil, err
+		}
+
+		local := c.socketTunnel.LocalAddr()
+		c.log.Debugf("socketPath: %s", local)
+		c.target = "passthrough:///" + local.Address
+		c.contextDial = func(ctx context.Context, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, local.Network, local.Address)
+		}
+	}
+
+	backoffConfig := backoff.DefaultConfig
+	backoffConfig.MaxDelay = 3 * time.Second
+	gopts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoffConfig}),
+		grpc.WithContextDialer(c.contextDial),
+		grpc.WithReturnConnectionError(),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(defaultMaxMsgSize),
+			grpc.MaxCallSendMsgSize(defaultMaxMsgSize)),
+	}
+	gopts = append(gopts, c.dialOpts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, c.target, gopts...)
+	if err != nil {
+		c.DoneAndWait()
+		return nil, fmt.Errorf("failed to dial grpc socket: %v", err)
+	}
+	c.ClientConn = conn
+
+	// try to connect to grpc socket
+	if err := c.pingWithRetry(); err != nil {
+		c.DoneAndWait()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// pingWithRetry run health check with retry to make sure the daemon is ready
+func (c *ClientWithTunnel) pingWithRetry() error {
+	var err error
+	for i := uint(0); i < c.maxRetry; i++ {
+		if i != 0 {
+			time.Sleep(1 * time.Second)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+		err = c.healthCheck(ctx, c.ClientConn)
+		cancel()
+		if err == nil {
+			c.log.Debugf("connected to grpc socket")
+			return nil
+		}
+
+		c.log.Debugf("failed to connect to grpc socket, retrying...: %v", err)
+	}
+
+	return fmt.Errorf("failed to connect to grpc socket: %v", err)
+}
+
+// startTunnel start socket tunnel in background, and log its error after it exits
+func (c *ClientWithTunnel) startTunnel() error {
+	if c.manager != nil {
+		if err := c.manager.Add(c.name, c.socketTunnel); err != nil {
+			return fmt.Errorf("failed to start grpc socket tunnel: %v", err)
+		}
+		return nil
+	}
+
+	errc, err := c.socketTunnel.Start(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to start grpc socket tunnel: %v", err)
+	}
+	go func() {
+		if err := <-errc; err != nil {
+			c.log.Errorf("grpc socket tunnel exited: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Target return the target of grpc dial, it's only used as authority because connections are dialed by ContextDialer
+func (c *ClientWithTunnel) Target() string {
+	return c.target
+}
+
+// ContextDialer dial a new connection to remote socket through the tunnel, addr is ignored.
+// It can be used by clients creating their own grpc connections, e.g. buildkit client
+func (c *ClientWithTunnel) ContextDialer(ctx context.Context, addr string) (net.Conn, error) {
+	return c.contextDial(ctx, addr)
+}
+
+// DoneAndWait close grpc connection, stop tunnel and wait for it to exit
+func (c *ClientWithTunnel) DoneAndWait() {
+	if c.ClientConn != nil {
+		c.ClientConn.Close()
+	}
+	if c.socketTunnel != nil {
+		if c.manager != nil {
+			if err := c.manager.Remove(c.name); err != nil {
+				c.log.Errorf("failed to remove grpc socket tunnel: %v", err)
+			}
+		} else {
+			c.socketTunnel.Stop()
+		}
+	}
+	if c.dialer != nil {
+		c.dialer.Close()
+	}
+}
+
+// WithLogger set logger for ClientWithTunnel
+func WithLogger(log log.Logger) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.log = log
+		return nil
+	}
+}
+
+// WithAutoRemoveLocalSocket will remove local socket when tunnel exit
+func WithAutoRemoveLocalSocket(c *ClientWithTunnel) error {
+	if c.socketTunnel != nil {
+		c.socketTunnel.AutoRemoveLocalSocket()
+	}
+	return nil
+}
+
+// WithDisableLogger disable all log output
+func WithDisableLogger(c *ClientWithTunnel) error {
+	c.log = &log.NoopLogger{}
+	return nil
+}
+
+// WithDialOpts append grpc dial options, they take precedence over the default ones
+func WithDialOpts(opts ...grpc.DialOption) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.dialOpts = append(c.dialOpts, opts...)
+		return nil
+	}
+}
+
+// WithHealthCheck set health check run after dialing, default is GRPCHealthCheck("")
+func WithHealthCheck(healthCheck HealthCheck) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.healthCheck = healthCheck
+		return nil
+	}
+}
+
+// WithPingRetry set max retry for connecting to grpc socket, default is 3
+func WithPingRetry(maxRetry uint) Opt {
+	return func(c *ClientWithTunnel) error {
+		c.maxRetry = maxRetry
+		return nil
+	}
+}
+
+// WithKeepAlive set keepalive interval and max missed replies of the ssh connection, default is 30s and 3
+func WithKeepAlive(interval time.Duration, maxMissed int) Opt {
+	return func(c *ClientWithTunnel) error {
+		if c.socketTunnel != nil {
+			c.socketTunnel.SetKeepAlive(interval, maxMissed)
+		}
+		if c.dialer != nil {
+			c.dialer.SetKeepAlive(interval, maxMissed)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,9 @@
+// Package shell builds commands run by posix shell of remote hosts
+package shell
+
+import "strings"
+
+// Quote quote s as a single argument of posix shell
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,131 @@
+package podman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/docker"
+)
+
+// DefaultRootfulSocket is the podman socket of root user
+const DefaultRootfulSocket = "/run/podman/podman.sock"
+
+// RootlessSocket return the podman socket of rootless user with uid
+func RootlessSocket(uid int) string {
+	return fmt.Sprintf("/run/user/%d/podman/podman.sock", uid)
+}
+
+// ClientWithTunnel is podman client with tunnel.
+// Podman serves a docker compatible api, so it embeds the docker client with tunnel,
+// use Connection to create a podman bindings connection on the same tunnel.
+type ClientWithTunnel struct {
+	*docker.ClientWithTunnel
+
+	remoteSocket string
+}
+
+// NewConnectionFunc is the signature of bindings.NewConnection of podman,
+// it's passed to Connection so that this package doesn't depend on podman
+type NewConnectionFunc func(ctx context.Context, uri string) (context.Context, error)
+
+// Opt is option for ClientWithTunnel, all options of docker package can be used
+type Opt = docker.Opt
+
+// NewClientWithTunnel create podman client with tunnel, localSocket and remoteSocket can be
+// "unix:///path/to/socket", "tcp://host:port" or a unix socket path without scheme.
+// If remoteSocket is empty, it's detected by the uid of remote user, see DetectRemoteSocket.
+// If localSocket is empty, no local socket file is created and podman client dials remote socket over ssh directly
+func NewClientWithTunnel(sshClient *ssh.Client, localSocket, remoteSocket string, opts ...Opt) (*ClientWithTunnel, error) {
+	if remoteSocket == "" {
+		var err error
+		if remoteSocket, err = DetectRemoteSocket(sshClient); err != nil {
+			return nil, err
+		}
+	}
+
+	cli, err := docker.NewClientWithTunnel(sshClient, localSocket, remoteSocket, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create podman client: %v", err)
+	}
+
+	return &ClientWithTunnel{
+		ClientWithTunnel: cli,
+		remoteSocket:     remoteSocket,
+	}, nil
+}
+
+// RemoteSocket return the podman socket on remote host
+func (c *ClientWithTunnel) RemoteSocket() string {
+	return c.remoteSocket
+}
+
+// URI return the listening address of local socket, which can be passed to bindings.NewConnection of podman,
+// it returns empty string if there is no local socket
+func (c *ClientWithTunnel) URI() string {
+	addr, ok := c.LocalAddr()
+	if !ok {
+		return ""
+	}
+	// podman bindings require absolute socket path
+	if addr.Network == "unix" {
+		if abs, err := filepath.Abs(addr.Address); err == nil {
+			addr.Address = abs
+		}
+	}
+	return addr.String()
+}
+
+// Connection return a podman bindings connection context on the tunnel, newConnection is bindings.NewConnection:
+//
+//	conn, err := podmanClient.Connection(ctx, bindings.NewConnection)
+//	containers, err := containers.List(conn, nil)
+//
+// Podman bindings dial the uri by themselves, so a local socket is required
+func (c *ClientWithTunnel) Connection(ctx context.Context, newConnection NewConnectionFunc) (context.Context, error) {
+	uri := c.URI()
+	if uri == "" {
+		return nil, errors.New("podman bindings connection requires a local socket")
+	}
+	conn, err := newConnection(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create podman bindings connection: %v", err)
+	}
+	return conn, nil
+}
+
+// DetectRemoteSocket return rootful podman socket if the remote user is root, otherwise return rootless socket of the user
+func DetectRemoteSocket(sshClient *ssh.Client) (string, error) {
+	uid, err := RemoteUID(sshClient)
+	if err != nil {
+		return "", err
+	}
+	if uid == 0 {
+		return DefaultRootfulSocket, nil
+	}
+	return RootlessSocket(uid), nil
+}
+
+// RemoteUID return uid of the remote user by running `id -u` over ssh
+func RemoteUID(sshClient *ssh.Client) (int, error) {
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ssh session: %v", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output("id -u")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get uid of remote user: %v", err)
+	}
+	uid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse uid of remote user %q: %v", out, err)
+	}
+	return uid, nil
+}
@@ -0,0 +1,404 @@
+package runtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	ctrd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	refdocker "github.com/containerd/containerd/reference/docker"
+
+	"github.com/aFlyBird0/sshcontainer/containerd"
+)
+
+// nameLabels are labels of container name set by nerdctl and CRI
+var nameLabels = []string{"nerdctl/name", "io.kubernetes.container.name"}
+
+type containerdRuntime struct {
+	client *containerd.ClientWithTunnel
+}
+
+// NewContainerd create Runtime of containerd client, containers and images are in the namespace of client.
+// Tasks are created without stdio, because FIFOs of containerd can't be opened over ssh,
+// so Exec with stdin or output and Logs return ErrNotSupported
+func NewContainerd(client *containerd.ClientWithTunnel) Runtime {
+	return &containerdRuntime{client: client}
+}
+
+func (r *containerdRuntime) Name() string {
+	return "containerd"
+}
+
+func (r *containerdRuntime) ListContainers(ctx context.Context, all bool) ([]Container, error) {
+	ctx = r.client.Context(ctx)
+	list, err := r.client.Containers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %v", err)
+	}
+	containers := make([]Container, 0, len(list))
+	for _, c := range list {
+		container, err := r.toContainer(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if all || container.State == StateRunning {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+func (r *containerdRuntime) InspectContainer(ctx context.Context, id string) (Container, error) {
+	ctx = r.client.Context(ctx)
+	c, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return Container{}, fmt.Errorf("failed to load container: %v", err)
+	}
+	return r.toContainer(ctx, c)
+}
+
+// toContainer convert container and state of its task
+func (r *containerdRuntime) toContainer(ctx context.Context, c ctrd.Container) (Container, error) {
+	info, err := c.Info(ctx, ctrd.WithoutRefreshedMetadata)
+	if err != nil {
+		return Container{}, fmt.Errorf("failed to get container info: %v", err)
+	}
+	container := Container{
+		ID:        info.ID,
+		Name:      info.ID,
+		Image:     info.Image,
+		State:     StateCreated,
+		Labels:    info.Labels,
+		CreatedAt: info.CreatedAt,
+	}
+	for _, label := range nameLabels {
+		if name := info.Labels[label]; name != "" {
+			container.Name = name
+			break
+		}
+	}
+
+	task, err := loadTask(ctx, c)
+	if err != nil {
+		return Container{}, err
+	}
+	if task == nil {
+		return container, nil
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return Container{}, fmt.Errorf("failed to get task status: %v", err)
+	}
+	switch status.Status {
+	case ctrd.Created:
+		container.State = StateCreated
+	case ctrd.Running:
+		container.State = StateRunning
+	case ctrd.Paused, ctrd.Pausing:
+		container.State = StatePaused
+	case ctrd.Stopped:
+		container.State = StateExited
+	default:
+		container.State = StateUnknown
+	}
+	return container, nil
+}
+
+func (r *containerdRuntime) StartContainer(ctx context.Context, id string) error {
+	ctx = r.client.Context(ctx)
+	c, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %v", err)
+	}
+
+	task, err := c.Task(ctx, nil)
+	switch {
+	case errdefs.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("failed to get task: %v", err)
+	default:
+		status, err := task.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get task status: %v", err)
+		}
+		switch status.Status {
+		case ctrd.Running:
+			return nil
+		case ctrd.Paused:
+			return task.Resume(ctx)
+		case ctrd.Created:
+			return task.Start(ctx)
+		}
+		// the stopped task can't be started again
+		if _, err := task.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete stopped task: %v", err)
+		}
+	}
+
+	task, err = c.NewTask(ctx, cio.NullIO)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+	if err := task.Start(ctx); err != nil {
+		task.Delete(ctx)
+		return fmt.Errorf("failed to start task: %v", err)
+	}
+	return nil
+}
+
+func (r *containerdRuntime) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	ctx = r.client.Context(ctx)
+	c, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %v", err)
+	}
+	task, err := loadTask(ctx, c)
+	if err != nil || task == nil {
+		return err
+	}
+	if err := r.killTask(ctx, task, timeout); err != nil {
+		return err
+	}
+	if _, err := task.Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+	return nil
+}
+
+func (r *containerdRuntime) RemoveContainer(ctx context.Context, id string, force bool) error {
+	ctx = r.client.Context(ctx)
+	c, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %v", err)
+	}
+
+	task, err := loadTask(ctx, c)
+	if err != nil {
+		return err
+	}
+	if task != nil {
+		status, err := task.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get task status: %v", err)
+		}
+		if status.Status != ctrd.Stopped {
+			if !force {
+				return fmt.Errorf("container %s is %s, stop it or remove it by force", id, status.Status)
+			}
+			if err := r.killTask(ctx, task, 0); err != nil {
+				return err
+			}
+		}
+		if _, err := task.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete task: %v", err)
+		}
+	}
+
+	if err := c.Delete(ctx, ctrd.WithSnapshotCleanup); err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
+	return nil
+}
+
+// loadTask return task of container, it returns nil if container has no task
+func loadTask(ctx context.Context, c ctrd.Container) (ctrd.Task, error) {
+	task, err := c.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %v", err)
+	}
+	return task, nil
+}
+
+// killTask send SIGTERM to running task and SIGKILL after timeout, zero timeout sends SIGKILL directly
+func (r *containerdRuntime) killTask(ctx context.Context, task ctrd.Task, timeout time.Duration) error {
+	status, err := task.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get task status: %v", err)
+	}
+	if status.Status == ctrd.Stopped {
+		return nil
+	}
+
+	// wait must be called before kill, otherwise the exit may be missed
+	exitC, err := task.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait task: %v", err)
+	}
+	signal := syscall.SIGTERM
+	if timeout <= 0 {
+		signal = syscall.SIGKILL
+	}
+	if err := task.Kill(ctx, signal); err != nil {
+		return fmt.Errorf("failed to kill task: %v", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-exitC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if signal != syscall.SIGKILL {
+		if err := task.Kill(ctx, syscall.SIGKILL); err != nil {
+			return fmt.Errorf("failed to kill task: %v", err)
+		}
+	}
+	select {
+	case <-exitC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *containerdRuntime) PullImage(ctx context.Context, ref string) error {
+	named, err := refdocker.ParseDockerRef(ref)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %q: %v", ref, err)
+	}
+	if _, err := r.client.Pull(r.client.Context(ctx), named.String(), ctrd.WithPullUnpack); err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	return nil
+}
+
+func (r *containerdRuntime) ListImages(ctx context.Context) ([]Image, error) {
+	ctx = r.client.Context(ctx)
+	list, err := r.client.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+
+	// images with the same target are tags of one image, like docker
+	var images []Image
+	index := make(map[string]int)
+	for _, image := range list {
+		id := image.Target().Digest.String()
+		if i, ok := index[id]; ok {
+			images[i].Tags = append(images[i].Tags, image.Name())
+			continue
+		}
+		size, err := image.Size(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get size of image %s: %v", image.Name(), err)
+		}
+		index[id] = len(images)
+		images = append(images, Image{
+			ID:        id,
+			Tags:      []string{image.Name()},
+			Size:      size,
+			CreatedAt: image.Metadata().CreatedAt,
+		})
+	}
+	return images, nil
+}
+
+func (r *containerdRuntime) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	if opts.Stdin != nil || opts.Stdout != nil || opts.Stderr != nil || opts.Tty {
+		return 0, fmt.Errorf("stdio of exec: %w", ErrNotSupported)
+	}
+
+	ctx = r.client.Context(ctx)
+	c, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load container: %v", err)
+	}
+	task, err := c.Task(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get task, container may not be running: %v", err)
+	}
+	spec, err := c.Spec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get container spec: %v", err)
+	}
+	if spec.Process == nil {
+		return 0, fmt.Errorf("container %s has no process spec", id)
+	}
+
+	// command runs with the process spec of container
+	pspec := *spec.Process
+	pspec.Args = opts.Cmd
+	pspec.Terminal = false
+	pspec.Env = append(append([]string{}, pspec.Env...), opts.Env...)
+	if opts.WorkingDir != "" {
+		pspec.Cwd = opts.WorkingDir
+	}
+	if opts.User != "" {
+		uid, gid, err := parseUser(opts.User)
+		if err != nil {
+			return 0, err
+		}
+		pspec.User.UID, pspec.User.GID = uid, gid
+	}
+
+	execID, err := randomID()
+	if err != nil {
+		return 0, err
+	}
+	process, err := task.Exec(ctx, execID, &pspec, cio.NullIO)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %v", err)
+	}
+	defer process.Delete(ctx)
+
+	exitC, err := process.Wait(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to wait exec: %v", err)
+	}
+	if err := process.Start(ctx); err != nil {
+		return 0, fmt.Errorf("failed to start exec: %v", err)
+	}
+	select {
+	case status := <-exitC:
+		code, _, err := status.Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get exit status of exec: %v", err)
+		}
+		return int(code), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (r *containerdRuntime) Logs(ctx context.Context, id string, opts LogsOptions) error {
+	return fmt.Errorf("logs of containerd: %w", ErrNotSupported)
+}
+
+// parseUser parse numeric "uid[:gid]", user names can't be resolved without the rootfs of container.
+// gid defaults to 0 like docker and runc for a uid without passwd entry
+func parseUser(user string) (uint32, uint32, error) {
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user must be numeric uid[:gid], got %q", user)
+	}
+	var gid uint64
+	if len(parts) == 2 {
+		if gid, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("user must be numeric uid[:gid], got %q", user)
+		}
+	}
+	return uint32(uid), uint32(gid), nil
+}
+
+// randomID return a random exec id
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate exec id: %v", err)
+	}
+	return "exec-" + hex.EncodeToString(b), nil
+}
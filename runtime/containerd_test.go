@@ -0,0 +1,28 @@
+package runtime
+
+import "testing"
+
+func TestParseUser(t *testing.T) {
+	cases := []struct {
+		user     string
+		uid, gid uint32
+		wantErr  bool
+	}{
+		{user: "0", uid: 0, gid: 0},
+		{user: "1000", uid: 1000, gid: 0},
+		{user: "1000:1001", uid: 1000, gid: 1001},
+		{user: "1000:", wantErr: true},
+		{user: "root", wantErr: true},
+		{user: "1000:staff", wantErr: true},
+	}
+	for _, c := range cases {
+		uid, gid, err := parseUser(c.user)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseUser(%q) error = %v, wantErr %v", c.user, err, c.wantErr)
+			continue
+		}
+		if uid != c.uid || gid != c.gid {
+			t.Errorf("parseUser(%q) = %d, %d, want %d, %d", c.user, uid, gid, c.uid, c.gid)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/aFlyBird0/sshcontainer/docker"
+)
+
+type dockerRuntime struct {
+	client *docker.ClientWithTunnel
+}
+
+// NewDocker create Runtime of docker client, it also works with podman client
+func NewDocker(client *docker.ClientWithTunnel) Runtime {
+	return &dockerRuntime{client: client}
+}
+
+func (r *dockerRuntime) Name() string {
+	return "docker"
+}
+
+func (r *dockerRuntime) ListContainers(ctx context.Context, all bool) ([]Container, error) {
+	list, err := r.client.ContainerList(ctx, types.ContainerListOptions{All: all})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %v", err)
+	}
+	containers := make([]Container, 0, len(list))
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers = append(containers, Container{
+			ID:        c.ID,
+			Name:      name,
+			Image:     c.Image,
+			State:     dockerState(c.State),
+			Labels:    c.Labels,
+			CreatedAt: time.Unix(c.Created, 0),
+		})
+	}
+	return containers, nil
+}
+
+func (r *dockerRuntime) InspectContainer(ctx context.Context, id string) (Container, error) {
+	c, err := r.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return Container{}, fmt.Errorf("failed to inspect container: %v", err)
+	}
+	container := Container{
+		ID:    c.ID,
+		Name:  strings.TrimPrefix(c.Name, "/"),
+		State: StateUnknown,
+	}
+	if c.Config != nil {
+		container.Image = c.Config.Image
+		container.Labels = c.Config.Labels
+	}
+	if c.State != nil {
+		container.State = dockerState(c.State.Status)
+	}
+	container.CreatedAt, _ = time.Parse(time.RFC3339Nano, c.Created)
+	return container, nil
+}
+
+func (r *dockerRuntime) StartContainer(ctx context.Context, id string) error {
+	if err := r.client.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
+	}
+	return nil
+}
+
+func (r *dockerRuntime) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	seconds := int(timeout.Seconds())
+	if err := r.client.ContainerStop(ctx, id, container.StopOptions{Timeout: &seconds}); err != nil {
+		return fmt.Errorf("failed to stop container: %v", err)
+	}
+	return nil
+}
+
+func (r *dockerRuntime) RemoveContainer(ctx context.Context, id string, force bool) error {
+	if err := r.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: force}); err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
+	return nil
+}
+
+func (r *dockerRuntime) PullImage(ctx context.Context, ref string) error {
+	rc, err := r.client.ImagePull(ctx, ref, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	defer rc.Close()
+	// errors of pulling are reported in the progress stream
+	if err := jsonmessage.DisplayJSONMessagesStream(rc, io.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	return nil
+}
+
+func (r *dockerRuntime) ListImages(ctx context.Context) ([]Image, error) {
+	list, err := r.client.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	images := make([]Image, 0, len(list))
+	for _, image := range list {
+		images = append(images, Image{
+			ID:        image.ID,
+			Tags:      image.RepoTags,
+			Size:      image.Size,
+			CreatedAt: time.Unix(image.Created, 0),
+		})
+	}
+	return images, nil
+}
+
+func (r *dockerRuntime) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	exec, err := r.client.ContainerExecCreate(ctx, id, types.ExecConfig{
+		User:         opts.User,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          opts.Env,
+		WorkingDir:   opts.WorkingDir,
+		Cmd:          opts.Cmd,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %v", err)
+	}
+
+	resp, err := r.client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: opts.Tty})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach exec: %v", err)
+	}
+	defer resp.Close()
+
+	if opts.Stdin != nil {
+		go func() {
+			io.Copy(resp.Conn, opts.Stdin)
+			resp.CloseWrite()
+		}()
+	}
+	if err := copyOutput(resp.Reader, opts.Tty, opts.Stdout, opts.Stderr); err != nil {
+		return 0, fmt.Errorf("failed to read output of exec: %v", err)
+	}
+
+	inspect, err := r.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %v", err)
+	}
+	return inspect.ExitCode, nil
+}
+
+func (r *dockerRuntime) Logs(ctx context.Context, id string, opts LogsOptions) error {
+	c, err := r.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %v", err)
+	}
+	// docker rejects logs request showing neither stdout nor stderr
+	if opts.Stdout == nil && opts.Stderr == nil {
+		return nil
+	}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: opts.Stdout != nil,
+		ShowStderr: opts.Stderr != nil,
+		Follow:     opts.Follow,
+		Timestamps: opts.Timestamps,
+	}
+	if opts.Tail > 0 {
+		options.Tail = strconv.Itoa(opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		options.Since = strconv.FormatInt(opts.Since.Unix(), 10)
+	}
+	rc, err := r.client.ContainerLogs(ctx, id, options)
+	if err != nil {
+		return fmt.Errorf("failed to get logs: %v", err)
+	}
+	defer rc.Close()
+
+	if err := copyOutput(rc, c.Config != nil && c.Config.Tty, opts.Stdout, opts.Stderr); err != nil {
+		return fmt.Errorf("failed to read logs: %v", err)
+	}
+	return nil
+}
+
+// dockerState map status of docker container to State, a restarting container is running for docker
+func dockerState(status string) State {
+	switch status {
+	case "created":
+		return StateCreated
+	case "running", "restarting":
+		return StateRunning
+	case "paused":
+		return StatePaused
+	case "exited", "dead":
+		return StateExited
+	default:
+		return StateUnknown
+	}
+}
+
+// copyOutput copy output of docker to stdout and stderr,
+// output is multiplexed unless tty is enabled
+func copyOutput(src io.Reader, tty bool, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, src)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, src)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotSupported is returned when an operation isn't supported by the runtime
+var ErrNotSupported = errors.New("operation is not supported by the runtime")
+
+// State is the state of container
+type State string
+
+const (
+	StateCreated State = "created"
+	StateRunning State = "running"
+	StatePaused  State = "paused"
+	StateExited  State = "exited"
+	StateUnknown State = "unknown"
+)
+
+// Container is a container of any runtime
+type Container struct {
+	ID        string
+	Name      string // name of docker container, or value of name label for containerd
+	Image     string
+	State     State
+	Labels    map[string]string
+	CreatedAt time.Time
+}
+
+// Image is an image of any runtime
+type Image struct {
+	ID        string // image id of docker, or digest of target for containerd
+	Tags      []string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// ExecOptions is options of running a command in container
+type ExecOptions struct {
+	Cmd        []string
+	Env        []string // "KEY=value"
+	WorkingDir string
+	User       string
+	Tty        bool
+	Stdin      io.Reader // nil if no stdin is needed
+	Stdout     io.Writer // nil to discard output
+	Stderr     io.Writer // nil to discard output, ignored if Tty is true
+}
+
+// LogsOptions is options of reading container logs
+type LogsOptions struct {
+	Follow     bool
+	Tail       int // number of lines from the end, 0 means all
+	Since      time.Time
+	Timestamps bool
+	Stdout     io.Writer // nil to skip stdout
+	Stderr     io.Writer // nil to skip stderr, ignored if the container has a tty
+}
+
+// Runtime is the common operations of container runtimes, implemented by adapters of
+// docker.ClientWithTunnel and containerd.ClientWithTunnel.
+// Operations return ErrNotSupported if the runtime can't support them
+type Runtime interface {
+	// Name return the name of the runtime, e.g. "docker" or "containerd"
+	Name() string
+
+	// ListContainers list running containers, or all containers if all is true
+	ListContainers(ctx context.Context, all bool) ([]Container, error)
+	InspectContainer(ctx context.Context, id string) (Container, error)
+	StartContainer(ctx context.Context, id string) error
+	// StopContainer send SIGTERM to container and SIGKILL after timeout
+	StopContainer(ctx context.Context, id string, timeout time.Duration) error
+	// RemoveContainer remove a stopped container, or a running one if force is true
+	RemoveContainer(ctx context.Context, id string, force bool) error
+
+	// PullImage pull image, ref is normalized like docker, e.g. "nginx" is "docker.io/library/nginx:latest"
+	PullImage(ctx context.Context, ref string) error
+	ListImages(ctx context.Context) ([]Image, error)
+
+	// Exec run command in a running container, and return the exit code of command
+	Exec(ctx context.Context, id string, opts ExecOptions) (int, error)
+	// Logs copy logs of container to writers of opts, it blocks until the end of logs,
+	// or until ctx is done if opts.Follow is true
+	Logs(ctx context.Context, id string, opts LogsOptions) error
+}
@@ -0,0 +1,81 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSocket return socket, or SSH_AUTH_SOCK if socket is empty
+func agentSocket(socket string) (string, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return "", errors.New("SSH_AUTH_SOCK is not set, ssh-agent may not be running")
+	}
+	return socket, nil
+}
+
+// WithAgent use publickey auth with keys of ssh-agent at SSH_AUTH_SOCK,
+// including keys of hardware tokens added to the agent
+func WithAgent() Opt {
+	return WithAgentSocket("")
+}
+
+// WithAgentSocket use publickey auth with keys of ssh-agent listening on socket, see WithAgent.
+// The agent is skipped like other failed signers if it isn't available, e.g. SSH_AUTH_SOCK is not set
+func WithAgentSocket(socket string) Opt {
+	return func(c *config) error {
+		c.signers = append(c.signers, func() ([]ssh.Signer, error) {
+			socket, err := agentSocket(socket)
+			if err != nil {
+				return nil, err
+			}
+			return c.agentSigners(socket, nil)
+		})
+		return nil
+	}
+}
+
+// agentSigners return keys of ssh-agent listening on socket, only keys accepted by filter are returned if it isn't nil
+func (c *config) agentSigners(socket string, filter func(ssh.PublicKey) bool) ([]ssh.Signer, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh-agent: %v", err)
+	}
+	// signing happens in handshake, so the connection is closed after Dial
+	c.closers = append(c.closers, conn)
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys of ssh-agent: %v", err)
+	}
+	if filter == nil {
+		return signers, nil
+	}
+	var filtered []ssh.Signer
+	for _, signer := range signers {
+		if filter(signer.PublicKey()) {
+			filtered = append(filtered, signer)
+		}
+	}
+	return filtered, nil
+}
+
+// WithAgentForwarding forward ssh-agent listening on socket (SSH_AUTH_SOCK if empty) to remote host,
+// like ForwardAgent of OpenSSH. It must also be requested by every session that uses it with
+// agent.RequestAgentForwarding(session)
+func WithAgentForwarding(socket string) Opt {
+	return func(c *config) error {
+		socket, err := agentSocket(socket)
+		if err != nil {
+			return err
+		}
+		c.agentForward = socket
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAgentWithoutSocketIsSkipped(t *testing.T) {
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Unsetenv("SSH_AUTH_SOCK")
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config{auths: map[string]ssh.AuthMethod{}}
+	for _, opt := range []Opt{WithAgent(), WithSigners(signer), WithPassword("secret")} {
+		if err := opt(c); err != nil {
+			t.Fatalf("option error = %v, want nil", err)
+		}
+	}
+	signers, err := c.publicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || string(signers[0].PublicKey().Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Errorf("signers = %v, want the key only", signers)
+	}
+
+	c = &config{auths: map[string]ssh.AuthMethod{}}
+	if err := WithAgent()(c); err != nil {
+		t.Fatalf("option error = %v, want nil", err)
+	}
+	if _, err := c.publicKeys(); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Errorf("error = %v, want SSH_AUTH_SOCK is not set", err)
+	}
+}
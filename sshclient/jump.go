@@ -0,0 +1,87 @@
+package sshclient
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHost is a host between the client and the target host
+type jumpHost struct {
+	addr string
+	user string
+	opts []Opt
+}
+
+// WithJumpHost dial the target host through jump host at addr, opts are auth and host key options of the jump host.
+// Jump hosts are dialed in the order of options, like ProxyJump=host1,host2 of OpenSSH,
+// and they are closed when the client of target host is closed
+func WithJumpHost(addr, user string, opts ...Opt) Opt {
+	return func(c *config) error {
+		c.jumpHosts = append(c.jumpHosts, jumpHost{addr: addr, user: user, opts: opts})
+		return nil
+	}
+}
+
+// WithJumpClient dial the target host through an existing client, it's used before jump hosts of WithJumpHost.
+// The client isn't closed with the client of target host
+func WithJumpClient(client *ssh.Client) Opt {
+	return func(c *config) error {
+		c.jumpClient = client
+		return nil
+	}
+}
+
+// dialJumpHosts dial all jump hosts starting from via, it returns the client of the last jump host,
+// and a func closing the jump hosts it dialed
+func (c *config) dialJumpHosts(via *ssh.Client) (*ssh.Client, func(), error) {
+	if c.jumpClient != nil {
+		via = c.jumpClient
+	}
+	var dialed []*ssh.Client
+	closeJumps := func() {
+		for i := len(dialed) - 1; i >= 0; i-- {
+			dialed[i].Close()
+		}
+	}
+
+	for _, hop := range c.jumpHosts {
+		client, err := dial(via, hop.addr, hop.user, hop.opts...)
+		if err != nil {
+			closeJumps()
+			return nil, nil, fmt.Errorf("failed to dial jump host: %v", err)
+		}
+		dialed = append(dialed, client)
+		via = client
+	}
+	return via, closeJumps, nil
+}
+
+// connect dial ssh server at addr through via, or directly if via is nil
+func connect(via *ssh.Client, addr string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", addr, clientConfig)
+	}
+
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// connection of jump host doesn't support deadline, so it's closed to stop handshake on timeout
+	timer := time.AfterFunc(clientConfig.Timeout, func() {
+		conn.Close()
+	})
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if !timer.Stop() {
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, fmt.Errorf("handshake timeout after %s", clientConfig.Timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
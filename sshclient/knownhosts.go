@@ -0,0 +1,199 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHostsMode is how KnownHosts handles hosts not in known_hosts
+type KnownHostsMode int
+
+const (
+	// KnownHostsStrict reject unknown hosts, like StrictHostKeyChecking=yes of OpenSSH
+	KnownHostsStrict KnownHostsMode = iota
+	// KnownHostsAcceptNew add unknown hosts to the first known_hosts file,
+	// like StrictHostKeyChecking=accept-new of OpenSSH. Changed host keys are still rejected
+	KnownHostsAcceptNew
+)
+
+// DefaultKnownHostsFile return ~/.ssh/known_hosts
+func DefaultKnownHostsFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home dir: %v", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// KnownHosts verify host keys by known_hosts files of OpenSSH,
+// hashed host entries and @cert-authority/@revoked markers are supported
+type KnownHosts struct {
+	files []string
+	mode  KnownHostsMode
+	hash  bool
+
+	mu sync.Mutex // files are reloaded and appended under mu
+}
+
+// NewKnownHosts create KnownHosts of files, files default to ~/.ssh/known_hosts.
+// Files that don't exist are treated as empty
+func NewKnownHosts(mode KnownHostsMode, files ...string) (*KnownHosts, error) {
+	if len(files) == 0 {
+		file, err := DefaultKnownHostsFile()
+		if err != nil {
+			return nil, err
+		}
+		files = []string{file}
+	}
+	return &KnownHosts{files: files, mode: mode}, nil
+}
+
+// SetHashHosts set whether hosts added in KnownHostsAcceptNew mode are hashed, like HashKnownHosts of OpenSSH
+func (k *KnownHosts) SetHashHosts(hash bool) *KnownHosts {
+	k.hash = hash
+	return k
+}
+
+// HostKeyCallback return the callback verifying host keys, it can be used in ssh.ClientConfig
+func (k *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return k.check
+}
+
+// HostKeyAlgorithms return the algorithms of keys known for addr, nil if addr is unknown.
+// They should be preferred in handshake, otherwise the server may send a key of another type
+// and it's rejected as a mismatch
+func (k *KnownHosts) HostKeyAlgorithms(addr string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	callback, err := k.load()
+	if err != nil {
+		return nil
+	}
+
+	// checking a key no host has returns all known keys of addr
+	var keyErr *knownhosts.KeyError
+	if err := callback(addr, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+func (k *KnownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// files are reloaded for every connection, so hosts added by others are seen
+	callback, err := k.load()
+	if err != nil {
+		return err
+	}
+
+	err = callback(hostname, remote, key)
+	var (
+		keyErr  *knownhosts.KeyError
+		revoked *knownhosts.RevokedError
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &revoked):
+		return fmt.Errorf("host key %s of %s is revoked by %s:%d",
+			ssh.FingerprintSHA256(key), hostname, revoked.Revoked.Filename, revoked.Revoked.Line)
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		known := make([]string, 0, len(keyErr.Want))
+		for _, w := range keyErr.Want {
+			known = append(known, fmt.Sprintf("%s %s (%s:%d)", w.Key.Type(), ssh.FingerprintSHA256(w.Key), w.Filename, w.Line))
+		}
+		return fmt.Errorf("host key mismatch for %s: server sent %s %s, but known_hosts has %s. "+
+			"The host key may have been changed, or someone may be doing a man-in-the-middle attack",
+			hostname, key.Type(), ssh.FingerprintSHA256(key), strings.Join(known, ", "))
+	case errors.As(err, &keyErr):
+		if k.mode != KnownHostsAcceptNew {
+			return fmt.Errorf("host %s is unknown (%s %s), add it to %s, e.g. with ssh-keyscan, or use KnownHostsAcceptNew",
+				hostname, key.Type(), ssh.FingerprintSHA256(key), k.files[0])
+		}
+		return k.add(hostname, key)
+	default:
+		return fmt.Errorf("failed to verify host key of %s: %v", hostname, err)
+	}
+}
+
+// load read all existing known_hosts files
+func (k *KnownHosts) load() (ssh.HostKeyCallback, error) {
+	var files []string
+	for _, file := range k.files {
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		files = append(files, file)
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %v", err)
+	}
+	return callback, nil
+}
+
+// add append host key to the first known_hosts file
+func (k *KnownHosts) add(hostname string, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(hostname)
+	if k.hash {
+		host = knownhosts.HashHostname(host)
+	}
+
+	file := k.files[0]
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("failed to create dir of known_hosts: %v", err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(knownhosts.Line([]string{host}, key) + "\n"); err != nil {
+		return fmt.Errorf("failed to add host to known_hosts: %v", err)
+	}
+	return nil
+}
+
+// placeholderKey is a key no host has
+var placeholderKey, _ = ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+// fingerprintCallback accept host keys matching one of SHA256 or MD5 fingerprints
+func fingerprintCallback(fingerprints []string) (ssh.HostKeyCallback, error) {
+	pinned := make(map[string]bool, len(fingerprints))
+	for _, fp := range fingerprints {
+		switch {
+		case strings.HasPrefix(fp, "SHA256:"):
+			// ssh-keygen prints SHA256 fingerprints without padding
+			pinned[strings.TrimRight(fp, "=")] = true
+		case strings.HasPrefix(fp, "MD5:"):
+			pinned[strings.ToLower(fp[len("MD5:"):])] = true
+		default:
+			return nil, fmt.Errorf("invalid host key fingerprint %q, it should start with SHA256: or MD5:", fp)
+		}
+	}
+
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		if pinned[ssh.FingerprintSHA256(key)] || pinned[ssh.FingerprintLegacyMD5(key)] {
+			return nil
+		}
+		return fmt.Errorf("host key %s %s of %s doesn't match the pinned fingerprints",
+			key.Type(), ssh.FingerprintSHA256(key), hostname)
+	}, nil
+}
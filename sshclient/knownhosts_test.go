@@ -0,0 +1,142 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// knownHostsFile write lines to a temp known_hosts file
+func knownHostsFile(t *testing.T, lines ...string) string {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if len(lines) > 0 {
+		if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file
+}
+
+var remoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+func TestKnownHostsStrict(t *testing.T) {
+	key, other := newHostKey(t), newHostKey(t)
+	file := knownHostsFile(t, knownhosts.Line([]string{"example.com"}, key))
+	k, err := NewKnownHosts(KnownHostsStrict, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := k.HostKeyCallback()
+
+	if err := check("example.com:22", remoteAddr, key); err != nil {
+		t.Errorf("known key error = %v, want nil", err)
+	}
+	if err := check("example.com:22", remoteAddr, other); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("changed key error = %v, want host key mismatch", err)
+	}
+	if err := check("unknown.com:22", remoteAddr, key); err == nil || !strings.Contains(err.Error(), "is unknown") {
+		t.Errorf("unknown host error = %v, want unknown", err)
+	}
+	if got := k.HostKeyAlgorithms("example.com:22"); !reflect.DeepEqual(got, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("host key algorithms = %q, want %q", got, ssh.KeyAlgoED25519)
+	}
+	if got := k.HostKeyAlgorithms("unknown.com:22"); got != nil {
+		t.Errorf("host key algorithms of unknown host = %q, want nil", got)
+	}
+}
+
+func TestKnownHostsAcceptNew(t *testing.T) {
+	for _, hash := range []bool{false, true} {
+		key, other := newHostKey(t), newHostKey(t)
+		// the file and its dir are created when the first host is added
+		file := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+		k, err := NewKnownHosts(KnownHostsAcceptNew, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := k.SetHashHosts(hash).HostKeyCallback()
+
+		if err := check("example.com:2222", remoteAddr, key); err != nil {
+			t.Fatalf("hash %v: new host error = %v, want nil", hash, err)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := strings.TrimSpace(string(content))
+		if hashed := strings.HasPrefix(line, "|1|"); hashed != hash || strings.Contains(line, "example.com") == hash {
+			t.Errorf("hash %v: added line = %q", hash, line)
+		}
+		if !hash && !strings.HasPrefix(line, "[example.com]:2222 ") {
+			t.Errorf("added line = %q, want host [example.com]:2222", line)
+		}
+
+		if err := check("example.com:2222", remoteAddr, key); err != nil {
+			t.Errorf("hash %v: added host error = %v, want nil", hash, err)
+		}
+		if err := check("example.com:2222", remoteAddr, other); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+			t.Errorf("hash %v: changed key error = %v, want host key mismatch", hash, err)
+		}
+		if content, _ := os.ReadFile(file); strings.Count(string(content), "\n") != 1 {
+			t.Errorf("hash %v: known_hosts = %q, want only the first key added", hash, content)
+		}
+	}
+}
+
+func TestKnownHostsRevoked(t *testing.T) {
+	key := newHostKey(t)
+	file := knownHostsFile(t,
+		"@revoked * "+strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		knownhosts.Line([]string{"example.com"}, key))
+	for _, mode := range []KnownHostsMode{KnownHostsStrict, KnownHostsAcceptNew} {
+		k, err := NewKnownHosts(mode, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := k.HostKeyCallback()("example.com:22", remoteAddr, key); err == nil || !strings.Contains(err.Error(), "revoked") {
+			t.Errorf("mode %d: revoked key error = %v, want revoked", mode, err)
+		}
+	}
+}
+
+func TestFingerprintCallback(t *testing.T) {
+	key, other := newHostKey(t), newHostKey(t)
+	sha256 := ssh.FingerprintSHA256(key)
+	md5 := ssh.FingerprintLegacyMD5(key)
+
+	for _, fp := range []string{sha256, sha256 + "=", "MD5:" + md5, "MD5:" + strings.ToUpper(md5)} {
+		callback, err := fingerprintCallback([]string{fp})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := callback("example.com:22", remoteAddr, key); err != nil {
+			t.Errorf("fingerprint %s error = %v, want nil", fp, err)
+		}
+		if err := callback("example.com:22", remoteAddr, other); err == nil {
+			t.Errorf("fingerprint %s accepts another key", fp)
+		}
+	}
+	if _, err := fingerprintCallback([]string{md5}); err == nil {
+		t.Error("fingerprint without prefix is accepted, want error")
+	}
+}
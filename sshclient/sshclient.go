@@ -0,0 +1,362 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+const (
+	defaultPort    = "22"
+	defaultTimeout = 10 * time.Second
+)
+
+// names of auth methods, see WithAuthOrder
+const (
+	AuthPublicKey           = "publickey"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	AuthPassword            = "password"
+)
+
+// defaultAuthOrder is the same as OpenSSH
+var defaultAuthOrder = []string{AuthPublicKey, AuthKeyboardInteractive, AuthPassword}
+
+// config is the config of dialing ssh client, it's built by Opt
+type config struct {
+	user            string
+	signers         []signerSource // agent, keys and certificates, they are tried in one publickey auth
+	auths           map[string]ssh.AuthMethod
+	authOrder       []string
+	agentForward    string      // agent socket forwarded to remote host, empty if forwarding is disabled
+	closers         []io.Closer // closed after handshake, e.g. connections to ssh-agent
+	fallback        bool        // there are auth methods other than publickey
+	jumpClient      *ssh.Client // existing client of the first jump host
+	jumpHosts       []jumpHost  // jump hosts dialed in order, like ProxyJump of OpenSSH
+	hostKeyCallback ssh.HostKeyCallback
+	hostKeyAlgos    func(addr string) []string // preferred host key algorithms of addr, nil to use the default
+	timeout         time.Duration
+}
+
+// signerSource return signers for publickey auth, it's called for every connection
+type signerSource func() ([]ssh.Signer, error)
+
+// Opt is option for Dial
+type Opt func(*config) error
+
+// Dial connect to ssh server at addr ("host" or "host:port") as user.
+// Auth methods are tried in the order of publickey, keyboard-interactive and password unless WithAuthOrder is given,
+// and signers of publickey auth (agent, keys and certificates) are tried in the order of options.
+// Host keys are verified by ~/.ssh/known_hosts strictly unless another host key option is given
+func Dial(addr, user string, opts ...Opt) (*ssh.Client, error) {
+	return dial(nil, addr, user, opts...)
+}
+
+// dial connect to ssh server through via, or directly if via is nil
+func dial(via *ssh.Client, addr, user string, opts ...Opt) (*ssh.Client, error) {
+	c := &config{user: user, auths: map[string]ssh.AuthMethod{}}
+	defer c.close()
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	addr = withDefaultPort(addr)
+	clientConfig, err := c.clientConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	via, closeJumps, err := c.dialJumpHosts(via)
+	if err != nil {
+		return nil, err
+	}
+	client, err := connect(via, addr, clientConfig)
+	if err != nil {
+		closeJumps()
+		return nil, fmt.Errorf("failed to dial ssh %s: %v", addr, err)
+	}
+	// jump hosts dialed for client live as long as it
+	go func() {
+		client.Wait()
+		closeJumps()
+	}()
+
+	if c.agentForward != "" {
+		if err := agent.ForwardToRemote(client, c.agentForward); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to forward ssh-agent: %v", err)
+		}
+	}
+	return client, nil
+}
+
+// Factory return a tunnel.ClientFactory dialing ssh client with Dial,
+// it can be used to create tunnels reconnecting automatically
+func Factory(addr, user string, opts ...Opt) tunnel.ClientFactory {
+	return func() (*ssh.Client, error) {
+		return Dial(addr, user, opts...)
+	}
+}
+
+// clientConfig build ssh.ClientConfig of addr
+func (c *config) clientConfig(addr string) (*ssh.ClientConfig, error) {
+	if c.hostKeyCallback == nil {
+		knownHosts, err := NewKnownHosts(KnownHostsStrict)
+		if err != nil {
+			return nil, err
+		}
+		if err := WithKnownHosts(knownHosts)(c); err != nil {
+			return nil, err
+		}
+	}
+
+	order := c.authOrder
+	if order == nil {
+		order = defaultAuthOrder
+	}
+	var auths []ssh.AuthMethod
+	for _, method := range order {
+		if method == AuthPublicKey && len(c.signers) > 0 {
+			// ssh client tries every auth method once, so all signers must be in one method
+			auths = append(auths, ssh.PublicKeysCallback(c.publicKeys))
+		} else if auth, ok := c.auths[method]; ok {
+			auths = append(auths, auth)
+		}
+	}
+	c.fallback = len(auths) > 1
+	if len(auths) == 0 {
+		return nil, errors.New("no authentication method")
+	}
+
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	clientConfig := &ssh.ClientConfig{
+		User:            c.user,
+		Auth:            auths,
+		HostKeyCallback: c.hostKeyCallback,
+		Timeout:         timeout,
+	}
+	if c.hostKeyAlgos != nil {
+		clientConfig.HostKeyAlgorithms = c.hostKeyAlgos(addr)
+	}
+	return clientConfig, nil
+}
+
+// publicKeys return signers of all sources, failed sources are skipped if there are other signers or auth methods,
+// e.g. password auth can still be tried if ssh-agent isn't running
+func (c *config) publicKeys() ([]ssh.Signer, error) {
+	var (
+		signers []ssh.Signer
+		errs    []string
+	)
+	for _, source := range c.signers {
+		s, err := source()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		signers = append(signers, s...)
+	}
+	if len(signers) == 0 && len(errs) > 0 && !c.fallback {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return signers, nil
+}
+
+func (c *config) close() {
+	for _, closer := range c.closers {
+		closer.Close()
+	}
+}
+
+// withDefaultPort append port 22 if addr has no port
+func withDefaultPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, defaultPort)
+	}
+	return addr
+}
+
+// WithPassword use password auth
+func WithPassword(password string) Opt {
+	return func(c *config) error {
+		c.auths[AuthPassword] = ssh.Password(password)
+		return nil
+	}
+}
+
+// WithKeyboardInteractive use keyboard-interactive auth, challenge answers the questions of server
+func WithKeyboardInteractive(challenge ssh.KeyboardInteractiveChallenge) Opt {
+	return func(c *config) error {
+		c.auths[AuthKeyboardInteractive] = ssh.KeyboardInteractive(challenge)
+		return nil
+	}
+}
+
+// WithKeyboardInteractivePassword use keyboard-interactive auth answering every question with password,
+// it's needed by servers which only enable password auth through PAM
+func WithKeyboardInteractivePassword(password string) Opt {
+	return WithKeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = password
+		}
+		return answers, nil
+	})
+}
+
+// WithPrivateKey use publickey auth with a PEM or OpenSSH format private key,
+// passphrase is needed if the key is encrypted
+func WithPrivateKey(pemBytes []byte, passphrase string) Opt {
+	return func(c *config) error {
+		signer, err := parsePrivateKey(pemBytes, passphrase)
+		if err != nil {
+			return err
+		}
+		c.signers = append(c.signers, staticSigners(signer))
+		return nil
+	}
+}
+
+// WithPrivateKeyFile use publickey auth with private key file, see WithPrivateKey
+func WithPrivateKeyFile(path, passphrase string) Opt {
+	return func(c *config) error {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read private key: %v", err)
+		}
+		return WithPrivateKey(pemBytes, passphrase)(c)
+	}
+}
+
+// WithCertificateFile use publickey auth with certificate signed by a CA trusted by server,
+// keyPath is the private key of certificate, passphrase is needed if the key is encrypted
+func WithCertificateFile(certPath, keyPath, passphrase string) Opt {
+	return func(c *config) error {
+		cert, err := readCertificate(certPath)
+		if err != nil {
+			return err
+		}
+
+		pemBytes, err := os.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read private key: %v", err)
+		}
+		signer, err := parsePrivateKey(pemBytes, passphrase)
+		if err != nil {
+			return err
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return fmt.Errorf("failed to create certificate signer: %v", err)
+		}
+		c.signers = append(c.signers, staticSigners(certSigner))
+		return nil
+	}
+}
+
+// WithSigners use publickey auth with signers, e.g. signers of hardware keys
+func WithSigners(signers ...ssh.Signer) Opt {
+	return func(c *config) error {
+		c.signers = append(c.signers, staticSigners(signers...))
+		return nil
+	}
+}
+
+// WithAuthOrder set the order of trying auth methods, methods not in the order aren't used.
+// Method names are AuthPublicKey, AuthKeyboardInteractive and AuthPassword, like PreferredAuthentications of OpenSSH
+func WithAuthOrder(methods ...string) Opt {
+	return func(c *config) error {
+		for _, method := range methods {
+			switch method {
+			case AuthPublicKey, AuthKeyboardInteractive, AuthPassword:
+			default:
+				return fmt.Errorf("unknown auth method %q", method)
+			}
+		}
+		c.authOrder = methods
+		return nil
+	}
+}
+
+// WithHostKeyCallback verify host key with callback
+func WithHostKeyCallback(callback ssh.HostKeyCallback) Opt {
+	return func(c *config) error {
+		c.hostKeyCallback = callback
+		c.hostKeyAlgos = nil
+		return nil
+	}
+}
+
+// WithKnownHosts verify host key by known_hosts files, see NewKnownHosts
+func WithKnownHosts(knownHosts *KnownHosts) Opt {
+	return func(c *config) error {
+		c.hostKeyCallback = knownHosts.HostKeyCallback()
+		c.hostKeyAlgos = knownHosts.HostKeyAlgorithms
+		return nil
+	}
+}
+
+// WithHostKeyFingerprint accept only host keys matching one of fingerprints,
+// e.g. "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s" or "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48"
+func WithHostKeyFingerprint(fingerprints ...string) Opt {
+	return func(c *config) error {
+		callback, err := fingerprintCallback(fingerprints)
+		if err != nil {
+			return err
+		}
+		return WithHostKeyCallback(callback)(c)
+	}
+}
+
+// WithInsecureIgnoreHostKey accept any host key, it should only be used for testing
+func WithInsecureIgnoreHostKey() Opt {
+	return WithHostKeyCallback(ssh.InsecureIgnoreHostKey())
+}
+
+// WithTimeout set timeout of establishing connection, default is 10s
+func WithTimeout(timeout time.Duration) Opt {
+	return func(c *config) error {
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// parsePrivateKey parse PEM or OpenSSH format private key, encrypted or not
+func parsePrivateKey(pemBytes []byte, passphrase string) (ssh.Signer, error) {
+	var (
+		signer ssh.Signer
+		err    error
+	)
+	if passphrase == "" {
+		signer, err = ssh.ParsePrivateKey(pemBytes)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("failed to parse private key: key is encrypted, passphrase is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return signer, nil
+}
+
+func staticSigners(signers ...ssh.Signer) signerSource {
+	return func() ([]ssh.Signer, error) {
+		return signers, nil
+	}
+}
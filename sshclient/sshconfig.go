@@ -0,0 +1,716 @@
+package sshclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+	"github.com/aFlyBird0/sshcontainer/tunnel"
+)
+
+const (
+	systemSSHConfig = "/etc/ssh/ssh_config"
+	// maxIncludeDepth is the same as OpenSSH
+	maxIncludeDepth = 16
+	// maxJumpDepth limit jump hosts which jump through themselves, e.g. ProxyJump in "Host *"
+	maxJumpDepth = 8
+)
+
+// defaultIdentityFiles are used if no IdentityFile is given, the same as OpenSSH
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ecdsa_sk", "~/.ssh/id_ed25519", "~/.ssh/id_ed25519_sk", "~/.ssh/id_dsa",
+}
+
+// HostConfig is the config of a host evaluated from ssh config files, like `ssh -G host`.
+// Supported directives are HostName, User, Port, IdentityFile, CertificateFile, IdentitiesOnly, IdentityAgent,
+// ForwardAgent, ProxyJump, StrictHostKeyChecking, UserKnownHostsFile, GlobalKnownHostsFile, HashKnownHosts,
+// PreferredAuthentications and ConnectTimeout, in Host and Match (all, canonical, final, host, originalhost, user,
+// localuser) blocks and included files. Match blocks with other criteria, e.g. exec, are skipped with a warning
+type HostConfig struct {
+	Alias                    string // host given to LoadHostConfig
+	HostName                 string
+	User                     string
+	Port                     string
+	IdentityFiles            []string
+	CertificateFiles         []string
+	IdentitiesOnly           bool
+	IdentityAgent            string   // socket of ssh-agent, empty if the agent isn't used
+	ForwardAgent             string   // socket of ssh-agent forwarded to remote host, empty if forwarding is disabled
+	ProxyJump                []string // jump hosts in the format of [user@]host[:port]
+	StrictHostKeyChecking    string
+	UserKnownHostsFiles      []string
+	GlobalKnownHostsFiles    []string
+	HashKnownHosts           bool
+	PreferredAuthentications []string
+	ConnectTimeout           time.Duration
+
+	files     []string   // config files, jump hosts are loaded from them too
+	log       log.Logger // logger of warnings of config files, jump hosts use it too
+	jumpDepth int
+}
+
+// LoadHostConfig evaluate ssh config files for host alias,
+// files default to ~/.ssh/config and /etc/ssh/ssh_config, default files that don't exist are skipped
+func LoadHostConfig(alias string, files ...string) (*HostConfig, error) {
+	return LoadHostConfigWithLogger(alias, logrus.New(), files...)
+}
+
+// LoadHostConfigWithLogger is LoadHostConfig logging warnings of ssh config with logger, e.g. unsupported Match criteria
+func LoadHostConfigWithLogger(alias string, logger log.Logger, files ...string) (*HostConfig, error) {
+	if logger == nil {
+		// HostConfig created by callers has no logger for jump hosts
+		logger = logrus.New()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home dir: %v", err)
+	}
+	localUser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	e := &sshConfigEvaluator{
+		alias:     alias,
+		home:      home,
+		localUser: localUser,
+		values:    map[string][]string{},
+		log:       logger,
+	}
+	if len(files) == 0 {
+		for _, file := range []string{filepath.Join(home, ".ssh", "config"), systemSSHConfig} {
+			if _, err := os.Stat(file); err == nil {
+				files = append(files, file)
+			}
+		}
+	}
+	for _, file := range files {
+		base := filepath.Join(home, ".ssh")
+		if file == systemSSHConfig {
+			base = filepath.Dir(systemSSHConfig)
+		}
+		if err := e.readFile(file, base, true, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	h, err := e.hostConfig()
+	if err != nil {
+		return nil, err
+	}
+	h.files = files
+	h.log = logger
+	return h, nil
+}
+
+// DialHost connect to host alias of ssh config like the ssh command, see LoadHostConfig.
+// opts are applied after options of ssh config, e.g. WithPassword or WithHostKeyCallback
+func DialHost(alias string, opts ...Opt) (*ssh.Client, error) {
+	h, err := LoadHostConfig(alias)
+	if err != nil {
+		return nil, err
+	}
+	hostOpts, err := h.Opts()
+	if err != nil {
+		return nil, err
+	}
+	return Dial(h.Addr(), h.User, append(hostOpts, opts...)...)
+}
+
+// HostFactory return a tunnel.ClientFactory dialing ssh client with DialHost,
+// ssh config is reloaded for every connection
+func HostFactory(alias string, opts ...Opt) tunnel.ClientFactory {
+	return func() (*ssh.Client, error) {
+		return DialHost(alias, opts...)
+	}
+}
+
+// Addr return host:port of host
+func (h *HostConfig) Addr() string {
+	return net.JoinHostPort(h.HostName, h.Port)
+}
+
+// Opts return options of Dial for the config, they don't include user and address, see Addr and User
+func (h *HostConfig) Opts() ([]Opt, error) {
+	opts := []Opt{withHostSigners(h)}
+
+	switch strings.ToLower(h.StrictHostKeyChecking) {
+	case "no", "off":
+		opts = append(opts, WithInsecureIgnoreHostKey())
+	default:
+		mode := KnownHostsStrict
+		if strings.ToLower(h.StrictHostKeyChecking) == "accept-new" {
+			mode = KnownHostsAcceptNew
+		}
+		files := append(append([]string{}, h.UserKnownHostsFiles...), h.GlobalKnownHostsFiles...)
+		if len(files) == 0 {
+			return nil, errors.New("no known_hosts file, UserKnownHostsFile and GlobalKnownHostsFile are none")
+		}
+		knownHosts, err := NewKnownHosts(mode, files...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKnownHosts(knownHosts.SetHashHosts(h.HashKnownHosts)))
+	}
+
+	if h.ForwardAgent != "" {
+		opts = append(opts, WithAgentForwarding(h.ForwardAgent))
+	}
+	if len(h.PreferredAuthentications) > 0 {
+		var order []string
+		for _, method := range h.PreferredAuthentications {
+			switch method {
+			case AuthPublicKey, AuthKeyboardInteractive, AuthPassword:
+				order = append(order, method)
+			}
+		}
+		opts = append(opts, WithAuthOrder(order...))
+	}
+	if h.ConnectTimeout > 0 {
+		opts = append(opts, WithTimeout(h.ConnectTimeout))
+	}
+
+	for i, jump := range h.ProxyJump {
+		if h.jumpDepth >= maxJumpDepth {
+			return nil, fmt.Errorf("too many jump hosts of %s, ProxyJump may be recursive", h.Alias)
+		}
+		hopOpts, addr, user, err := h.jumpHost(jump, i > 0)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithJumpHost(addr, user, hopOpts...))
+	}
+	return opts, nil
+}
+
+// jumpHost return dial options of jump host "[user@]host[:port]", the host is loaded from ssh config too.
+// ProxyJump of the jump host is ignored if it isn't the first one, like OpenSSH
+func (h *HostConfig) jumpHost(jump string, ignoreProxyJump bool) ([]Opt, string, string, error) {
+	var jumpUser, port string
+	host := jump
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		jumpUser, host = host[:i], host[i+1:]
+	}
+	if hostPart, portPart, err := net.SplitHostPort(host); err == nil {
+		host, port = hostPart, portPart
+	}
+
+	hop, err := LoadHostConfigWithLogger(host, h.log, h.files...)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to load config of jump host %s: %v", jump, err)
+	}
+	hop.jumpDepth = h.jumpDepth + 1
+	if ignoreProxyJump {
+		hop.ProxyJump = nil
+	}
+	if jumpUser != "" {
+		hop.User = jumpUser
+	}
+	if port != "" {
+		hop.Port = port
+	}
+	opts, err := hop.Opts()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return opts, hop.Addr(), hop.User, nil
+}
+
+// withHostSigners use agent, identity files and certificates of host config in publickey auth,
+// identity files that can't be loaded are skipped, e.g. encrypted keys which are usually added to the agent
+func withHostSigners(h *HostConfig) Opt {
+	return func(c *config) error {
+		c.signers = append(c.signers, func() ([]ssh.Signer, error) {
+			var (
+				keys  []ssh.Signer
+				certs []ssh.Signer
+				errs  []string
+			)
+			for _, file := range h.IdentityFiles {
+				pemBytes, err := os.ReadFile(file)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("failed to read private key: %v", err))
+					continue
+				}
+				signer, err := parsePrivateKey(pemBytes, "")
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", file, err))
+					continue
+				}
+				keys = append(keys, signer)
+			}
+
+			// certificates are paired with the keys by public key, "<IdentityFile>-cert.pub" are loaded too
+			certFiles := append([]string{}, h.CertificateFiles...)
+			for _, file := range h.IdentityFiles {
+				certFiles = append(certFiles, file+"-cert.pub")
+			}
+			for _, file := range certFiles {
+				cert, err := readCertificate(file)
+				if err != nil {
+					continue
+				}
+				for _, key := range keys {
+					if string(key.PublicKey().Marshal()) == string(cert.Key.Marshal()) {
+						if certSigner, err := ssh.NewCertSigner(cert, key); err == nil {
+							certs = append(certs, certSigner)
+						}
+					}
+				}
+			}
+
+			signers := certs
+			if h.IdentityAgent != "" {
+				agentKeys, err := c.agentSigners(h.IdentityAgent, h.identityFilter())
+				if err != nil {
+					errs = append(errs, err.Error())
+				}
+				signers = append(signers, agentKeys...)
+			}
+			signers = append(signers, keys...)
+			if len(signers) == 0 && len(errs) > 0 {
+				return nil, errors.New(strings.Join(errs, "; "))
+			}
+			return signers, nil
+		})
+		return nil
+	}
+}
+
+// identityFilter return the filter of agent keys, only keys of identity files are used if IdentitiesOnly is set.
+// Public keys are read from "<IdentityFile>.pub"
+func (h *HostConfig) identityFilter() func(ssh.PublicKey) bool {
+	if !h.IdentitiesOnly {
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, file := range h.IdentityFiles {
+		pubBytes, err := os.ReadFile(file + ".pub")
+		if err != nil {
+			continue
+		}
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes); err == nil {
+			allowed[string(pub.Marshal())] = true
+		}
+	}
+	return func(key ssh.PublicKey) bool {
+		return allowed[string(key.Marshal())]
+	}
+}
+
+// readCertificate read ssh certificate in authorized_keys format
+func readCertificate(path string) (*ssh.Certificate, error) {
+	certBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", path)
+	}
+	return cert, nil
+}
+
+// sshConfigEvaluator evaluate ssh config files for a host, the first value of a directive wins like OpenSSH
+type sshConfigEvaluator struct {
+	alias     string
+	home      string
+	localUser string
+	values    map[string][]string // lower case directive -> args
+	log       log.Logger
+}
+
+// multiValueDirectives are directives whose values are accumulated instead of the first one wins
+var multiValueDirectives = map[string]bool{
+	"identityfile":    true,
+	"certificatefile": true,
+}
+
+// readFile evaluate file, active is whether the lines before Host or Match are applied.
+// Relative paths of Include are relative to base
+func (e *sshConfigEvaluator) readFile(file, base string, active bool, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("too many nested includes in %s", file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open ssh config: %v", err)
+	}
+	defer f.Close()
+
+	// no Host or Match of an included file is matched if the Include is in an inactive block
+	canMatch := active
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", file, lineNo, err)
+		}
+		if keyword == "" {
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: missing argument of %s", file, lineNo, keyword)
+		}
+
+		switch keyword {
+		case "host":
+			active = canMatch && e.matchHost(args)
+		case "match":
+			matched, unsupported, err := e.match(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", file, lineNo, err)
+			}
+			if unsupported != "" && canMatch {
+				e.log.Warnf("%s:%d: Match %s is not supported, the block is skipped", file, lineNo, unsupported)
+			}
+			active = canMatch && matched
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range args {
+				pattern = e.expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(base, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid Include %q: %v", file, lineNo, pattern, err)
+				}
+				for _, match := range matches {
+					if err := e.readFile(match, base, active, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if !active {
+				continue
+			}
+			if multiValueDirectives[keyword] {
+				e.values[keyword] = append(e.values[keyword], args...)
+			} else if _, ok := e.values[keyword]; !ok {
+				e.values[keyword] = args
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ssh config: %v", err)
+	}
+	return nil
+}
+
+// matchHost match alias with patterns of Host
+func (e *sshConfigEvaluator) matchHost(patterns []string) bool {
+	return matchPatternList(strings.Join(patterns, ","), e.alias)
+}
+
+// match evaluate criteria of Match, all criteria must be matched.
+// Match with unsupported criteria is never matched, the first unsupported criterion is returned
+func (e *sshConfigEvaluator) match(args []string) (matched bool, unsupported string, err error) {
+	matched = true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var result bool
+		switch criterion {
+		case "all":
+			result = true
+		case "canonical":
+			// host names are never canonicalized
+			result = false
+		case "final":
+			// config is evaluated in one pass, which is the final one
+			result = true
+		case "host", "originalhost", "user", "localuser":
+			if i+1 >= len(args) {
+				return false, "", fmt.Errorf("missing argument of Match %s", criterion)
+			}
+			i++
+			result = matchPatternList(args[i], e.matchValue(criterion))
+		default:
+			// e.g. exec, localnetwork and tagged, they take an argument
+			if unsupported == "" {
+				unsupported = args[i]
+			}
+			i++
+			matched = false
+			continue
+		}
+		if result == negate {
+			matched = false
+		}
+	}
+	return matched, unsupported, nil
+}
+
+// matchValue return the value matched by criterion of Match with the config evaluated so far
+func (e *sshConfigEvaluator) matchValue(criterion string) string {
+	switch criterion {
+	case "host":
+		if hostName := e.first("hostname"); hostName != "" {
+			return strings.NewReplacer("%%", "%", "%h", e.alias).Replace(hostName)
+		}
+		return e.alias
+	case "originalhost":
+		return e.alias
+	case "user":
+		if u := e.first("user"); u != "" {
+			return u
+		}
+		return e.localUser
+	default:
+		return e.localUser
+	}
+}
+
+// first return the first argument of directive, empty if it's not set
+func (e *sshConfigEvaluator) first(directive string) string {
+	if args := e.values[directive]; len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+// hostConfig resolve HostConfig from evaluated values, tokens and "~" are expanded
+func (e *sshConfigEvaluator) hostConfig() (*HostConfig, error) {
+	h := &HostConfig{
+		Alias:                 e.alias,
+		HostName:              e.alias,
+		User:                  e.localUser,
+		Port:                  defaultPort,
+		StrictHostKeyChecking: "ask",
+		UserKnownHostsFiles:   []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"},
+		GlobalKnownHostsFiles: []string{"/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2"},
+		IdentityAgent:         os.Getenv("SSH_AUTH_SOCK"),
+	}
+	if v := e.first("hostname"); v != "" {
+		h.HostName = strings.NewReplacer("%%", "%", "%h", e.alias).Replace(v)
+	}
+	if v := e.first("user"); v != "" {
+		h.User = v
+	}
+	if v := e.first("port"); v != "" {
+		if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid Port %q", v)
+		}
+		h.Port = v
+	}
+	if v := e.first("stricthostkeychecking"); v != "" {
+		h.StrictHostKeyChecking = v
+	}
+	if v := e.first("identitiesonly"); v != "" {
+		h.IdentitiesOnly = isYes(v)
+	}
+	if v := e.first("hashknownhosts"); v != "" {
+		h.HashKnownHosts = isYes(v)
+	}
+	if v := e.first("connecttimeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ConnectTimeout %q", v)
+		}
+		h.ConnectTimeout = time.Duration(seconds) * time.Second
+	}
+	if v := e.first("preferredauthentications"); v != "" {
+		h.PreferredAuthentications = strings.Split(v, ",")
+	}
+	if v := e.first("proxyjump"); v != "" && strings.ToLower(v) != "none" {
+		h.ProxyJump = strings.Split(v, ",")
+	}
+
+	// paths may contain tokens of host, so they are expanded at last
+	if v, ok := e.values["userknownhostsfile"]; ok {
+		h.UserKnownHostsFiles = noneToEmpty(v)
+	}
+	if v, ok := e.values["globalknownhostsfile"]; ok {
+		h.GlobalKnownHostsFiles = noneToEmpty(v)
+	}
+	if v := e.first("identityagent"); v != "" {
+		switch {
+		case strings.ToLower(v) == "none":
+			h.IdentityAgent = ""
+		case v != "SSH_AUTH_SOCK":
+			h.IdentityAgent = v
+		}
+	}
+	if v := e.first("forwardagent"); v != "" {
+		switch {
+		case isYes(v):
+			h.ForwardAgent = h.IdentityAgent
+		case strings.ToLower(v) == "no" || strings.ToLower(v) == "false":
+		default:
+			h.ForwardAgent = v
+		}
+	}
+	h.IdentityFiles = noneToEmpty(e.values["identityfile"])
+	if _, ok := e.values["identityfile"]; !ok {
+		for _, file := range defaultIdentityFiles {
+			if _, err := os.Stat(e.expandHome(file)); err == nil {
+				h.IdentityFiles = append(h.IdentityFiles, file)
+			}
+		}
+	}
+	h.CertificateFiles = noneToEmpty(e.values["certificatefile"])
+
+	for _, paths := range [][]string{h.IdentityFiles, h.CertificateFiles, h.UserKnownHostsFiles, h.GlobalKnownHostsFiles} {
+		for i := range paths {
+			paths[i] = e.expandPath(paths[i], h)
+		}
+	}
+	if h.IdentityAgent != "" {
+		h.IdentityAgent = e.expandPath(h.IdentityAgent, h)
+	}
+	if h.ForwardAgent != "" {
+		h.ForwardAgent = e.expandPath(h.ForwardAgent, h)
+	}
+	return h, nil
+}
+
+// expandPath expand "~" and tokens of path, e.g. %h, %p, %r, %u, %d, %n and %i
+func (e *sshConfigEvaluator) expandPath(path string, h *HostConfig) string {
+	path = strings.NewReplacer(
+		"%%", "%",
+		"%h", h.HostName,
+		"%p", h.Port,
+		"%r", h.User,
+		"%u", e.localUser,
+		"%d", e.home,
+		"%n", e.alias,
+		"%i", strconv.Itoa(os.Getuid()),
+	).Replace(path)
+	return e.expandHome(path)
+}
+
+func (e *sshConfigEvaluator) expandHome(path string) string {
+	if path == "~" {
+		return e.home
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(e.home, path[2:])
+	}
+	return path
+}
+
+// splitSSHConfigLine split line into lower case keyword and arguments,
+// keyword and arguments are separated by spaces or "=", arguments may be double quoted
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+		inArg   bool
+	)
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case r == '#' && !quoted && !inArg:
+			// comment at the end of line
+			return keyword, args, nil
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return "", nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return keyword, args, nil
+}
+
+// matchPatternList match s with comma separated patterns, patterns starting with "!" are negated.
+// It's matched if any pattern is matched and no negated one is matched
+func matchPatternList(patterns, s string) bool {
+	s = strings.ToLower(s)
+	matched := false
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if strings.HasPrefix(pattern, "!") {
+			if matchPattern(pattern[1:], s) {
+				return false
+			}
+		} else if matchPattern(pattern, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern match s with pattern of "*" and "?" wildcards
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func isYes(v string) bool {
+	v = strings.ToLower(v)
+	return v == "yes" || v == "true"
+}
+
+// noneToEmpty return nil if args is "none"
+func noneToEmpty(args []string) []string {
+	if len(args) == 1 && strings.ToLower(args[0]) == "none" {
+		return nil
+	}
+	return args
+}
@@ -0,0 +1,255 @@
+package sshclient
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+func TestSplitSSHConfigLine(t *testing.T) {
+	cases := []struct {
+		line    string
+		keyword string
+		args    []string
+		wantErr bool
+	}{
+		{line: ""},
+		{line: "   "},
+		{line: "# comment"},
+		{line: "Host prod staging", keyword: "host", args: []string{"prod", "staging"}},
+		{line: "  Port\t2222  ", keyword: "port", args: []string{"2222"}},
+		{line: "HostName=example.com", keyword: "hostname", args: []string{"example.com"}},
+		{line: "HostName = example.com", keyword: "hostname", args: []string{"example.com"}},
+		{line: `IdentityFile "/path with space/key"`, keyword: "identityfile", args: []string{"/path with space/key"}},
+		{line: `Match exec "test -f /tmp/x" host prod`, keyword: "match", args: []string{"exec", "test -f /tmp/x", "host", "prod"}},
+		{line: "User root # comment", keyword: "user", args: []string{"root"}},
+		{line: "User ro#ot", keyword: "user", args: []string{"ro#ot"}},
+		{line: "Compression", keyword: "compression"},
+		{line: `ProxyCommand "unterminated`, wantErr: true},
+	}
+	for _, c := range cases {
+		keyword, args, err := splitSSHConfigLine(c.line)
+		if (err != nil) != c.wantErr {
+			t.Errorf("splitSSHConfigLine(%q) error = %v, wantErr %v", c.line, err, c.wantErr)
+			continue
+		}
+		if keyword != c.keyword || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("splitSSHConfigLine(%q) = %q, %q, want %q, %q", c.line, keyword, args, c.keyword, c.args)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	cases := []struct {
+		patterns string
+		s        string
+		want     bool
+	}{
+		{"*", "anything", true},
+		{"prod", "prod", true},
+		{"prod", "prod1", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"web?", "web1", true},
+		{"web?", "web12", false},
+		{"PROD*", "prod-1", true},
+		{"staging, prod", "prod", true},
+		{"*.example.com,!bad.example.com", "good.example.com", true},
+		{"*.example.com,!bad.example.com", "bad.example.com", false},
+		{"!prod", "staging", false},
+		{"10.0.0.*", "10.0.0.5", true},
+	}
+	for _, c := range cases {
+		if got := matchPatternList(c.patterns, c.s); got != c.want {
+			t.Errorf("matchPatternList(%q, %q) = %v, want %v", c.patterns, c.s, got, c.want)
+		}
+	}
+}
+
+func TestLoadHostConfigFiles(t *testing.T) {
+	cases := []struct {
+		name    string
+		alias   string
+		files   map[string]string // path relative to home -> content, the config is .ssh/config
+		check   func(t *testing.T, home string, h *HostConfig)
+		wantErr string
+	}{
+		{
+			name:  "first value wins",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host prod\n  Port 2222\n  User deploy\nHost *\n  Port 22\n  User root\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.Port != "2222" || h.User != "deploy" {
+					t.Errorf("port, user = %s, %s, want 2222, deploy", h.Port, h.User)
+				}
+			},
+		},
+		{
+			name:  "identity files accumulate",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host prod\n  IdentityFile /keys/a\nHost *\n  IdentityFile /keys/b\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if want := []string{"/keys/a", "/keys/b"}; !reflect.DeepEqual(h.IdentityFiles, want) {
+					t.Errorf("identity files = %q, want %q", h.IdentityFiles, want)
+				}
+			},
+		},
+		{
+			name:  "include relative to ~/.ssh",
+			alias: "prod",
+			files: map[string]string{
+				".ssh/config":      "Include conf.d/*\nHost *\n  User fallback\n",
+				".ssh/conf.d/prod": "Host prod\n  HostName prod.example.com\n  User deploy\n",
+				".ssh/conf.d/misc": "Host staging\n  User staging\n",
+			},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.HostName != "prod.example.com" || h.User != "deploy" {
+					t.Errorf("hostname, user = %s, %s, want prod.example.com, deploy", h.HostName, h.User)
+				}
+			},
+		},
+		{
+			name:  "include in inactive block",
+			alias: "prod",
+			files: map[string]string{
+				".ssh/config": "Host staging\n  Include other\nHost *\n  User fallback\n",
+				".ssh/other":  "User other\n",
+			},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.User != "fallback" {
+					t.Errorf("user = %s, want fallback", h.User)
+				}
+			},
+		},
+		{
+			name:    "recursive include",
+			alias:   "prod",
+			files:   map[string]string{".ssh/config": "Include config\n"},
+			wantErr: "too many nested includes",
+		},
+		{
+			name:  "match host uses hostname",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host prod\n  HostName 10.0.0.5\nMatch host 10.0.0.*\n  User ops\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.User != "ops" {
+					t.Errorf("user = %s, want ops", h.User)
+				}
+			},
+		},
+		{
+			name:  "match originalhost, user and localuser",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host prod\n  HostName 10.0.0.5\n  User deploy\n" +
+				"Match originalhost prod user deploy localuser me\n  Port 2222\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.Port != "2222" {
+					t.Errorf("port = %s, want 2222", h.Port)
+				}
+			},
+		},
+		{
+			name:  "match negation, all, canonical and final",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Match canonical\n  Port 1\nMatch !host staging final\n  Port 2\nMatch all\n  Port 3\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.Port != "2" {
+					t.Errorf("port = %s, want 2", h.Port)
+				}
+			},
+		},
+		{
+			name:  "unsupported match criteria are skipped",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Match host staging exec \"false\"\n  Port 1\n" +
+				"Match exec \"true\" host prod\n  Port 2\nMatch !tagged work\n  Port 3\nHost *\n  Port 4\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.Port != "4" {
+					t.Errorf("port = %s, want 4", h.Port)
+				}
+			},
+		},
+		{
+			name:    "missing argument of match",
+			alias:   "prod",
+			files:   map[string]string{".ssh/config": "Match host\n  Port 1\n"},
+			wantErr: "missing argument of Match host",
+		},
+		{
+			name:  "tokens and home are expanded",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host prod\n  HostName %h.example.com\n  User deploy\n  Port 2222\n" +
+				"  IdentityFile ~/.ssh/id_%n_%r\n  CertificateFile %d/certs/%h-%p-%u.pub\n" +
+				"  UserKnownHostsFile ~/.ssh/known_hosts_%i 100%%\n  IdentityAgent ~/agent.sock\n"},
+			check: func(t *testing.T, home string, h *HostConfig) {
+				if h.HostName != "prod.example.com" {
+					t.Errorf("hostname = %s, want prod.example.com", h.HostName)
+				}
+				if want := []string{filepath.Join(home, ".ssh/id_prod_deploy")}; !reflect.DeepEqual(h.IdentityFiles, want) {
+					t.Errorf("identity files = %q, want %q", h.IdentityFiles, want)
+				}
+				if want := []string{home + "/certs/prod.example.com-2222-me.pub"}; !reflect.DeepEqual(h.CertificateFiles, want) {
+					t.Errorf("certificate files = %q, want %q", h.CertificateFiles, want)
+				}
+				want := []string{filepath.Join(home, ".ssh/known_hosts_"+strconv.Itoa(os.Getuid())), "100%"}
+				if !reflect.DeepEqual(h.UserKnownHostsFiles, want) {
+					t.Errorf("user known hosts files = %q, want %q", h.UserKnownHostsFiles, want)
+				}
+				if want := filepath.Join(home, "agent.sock"); h.IdentityAgent != want {
+					t.Errorf("identity agent = %s, want %s", h.IdentityAgent, want)
+				}
+			},
+		},
+		{
+			name:  "none disables files",
+			alias: "prod",
+			files: map[string]string{".ssh/config": "Host *\n  UserKnownHostsFile none\n  IdentityAgent none\n  ProxyJump none\n"},
+			check: func(t *testing.T, _ string, h *HostConfig) {
+				if h.UserKnownHostsFiles != nil || h.IdentityAgent != "" || h.ProxyJump != nil {
+					t.Errorf("known hosts, agent, jump = %q, %q, %q, want empty", h.UserKnownHostsFiles, h.IdentityAgent, h.ProxyJump)
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			home, err := os.MkdirTemp("", "sshconfig-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(home)
+			for path, content := range c.files {
+				path = filepath.Join(home, path)
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			e := &sshConfigEvaluator{alias: c.alias, home: home, localUser: "me", values: map[string][]string{}, log: &log.NoopLogger{}}
+			err = e.readFile(filepath.Join(home, ".ssh", "config"), filepath.Join(home, ".ssh"), true, 0)
+			var h *HostConfig
+			if err == nil {
+				h, err = e.hostConfig()
+			}
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("error = %v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.check(t, home, h)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+// handler forwards an accepted connection, ctx is canceled when the connection is closed on drain
+type handler func(ctx context.Context, conn net.Conn) error
+
+// acceptor accepts connections from a listener and tracks them until it's stopped,
+// it holds the lifecycle shared by SocketTunnel and ReverseTunnel
+type acceptor struct {
+	log          log.Logger
+	conns        *connRegistry // active connections
+	drainTimeout time.Duration // max wait time for active connections to finish on stop
+	ready        chan struct{} // closed when listener is set
+	close        chan struct{} // closed when Stop is called
+	closeOnce    sync.Once
+	done         chan struct{} // closed when all connections are closed
+	listener     net.Listener
+}
+
+func newAcceptor(logger log.Logger) acceptor {
+	return acceptor{
+		log:   logger,
+		conns: newConnRegistry(),
+		ready: make(chan struct{}),
+		close: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Ready return a channel which is closed when the tunnel is listening
+func (a *acceptor) Ready() <-chan struct{} {
+	return a.ready
+}
+
+// ActiveConnections return info of all active connections
+func (a *acceptor) ActiveConnections() []ConnectionInfo {
+	return a.conns.list()
+}
+
+// started report whether the listener is set
+func (a *acceptor) started() bool {
+	select {
+	case <-a.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// setListener set the listener and mark acceptor as ready
+func (a *acceptor) setListener(listener net.Listener) {
+	a.listener = listener
+	close(a.ready)
+}
+
+// serve connections with handle until ctx is done or stop is called,
+// dialAddr is the address connections are forwarded to, it's only used as connection info
+func (a *acceptor) serve(ctx context.Context, dialAddr string, handle handler) error {
+	defer close(a.done)
+
+	// cancel dialing of pending connections when they are closed on drain
+	dialCtx, cancelDial := context.WithCancel(context.Background())
+	defer cancelDial()
+	defer a.drain(cancelDial)
+
+	// stop accepting before draining
+	defer a.listener.Close()
+
+	c := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	go a.newConnectionWaiter(c, acceptErr)
+
+	for {
+		select {
+		case <-a.close:
+			// close signal received
+			a.log.Debugf("received close signal\n")
+			return nil
+		case <-ctx.Done():
+			a.log.Debugf("context is done: %v\n", ctx.Err())
+			return nil
+		case err := <-acceptErr:
+			return fmt.Errorf("failed to accept connection: %v", err)
+		case conn := <-c:
+			id := a.conns.add(conn, dialAddr)
+			a.log.Debugf("accepted connection %d\n", id)
+
+			go func() {
+				defer a.conns.remove(id)
+				err := handle(dialCtx, conn)
+				if err != nil {
+					a.log.Errorf("failed to forward connection %d: %v\n", id, err)
+				}
+				a.log.Debugf("connection %d is finished\n", id)
+			}()
+		}
+	}
+}
+
+// drain wait for active connections to finish until drain timeout, then close the rest
+func (a *acceptor) drain(cancelDial context.CancelFunc) {
+	if a.drainTimeout > 0 {
+		a.log.Debugf("waiting for %d connections to finish\n", len(a.conns.list()))
+		if a.conns.wait(a.drainTimeout) {
+			return
+		}
+	}
+
+	cancelDial()
+	if total := a.conns.closeAll(); total > 0 {
+		a.log.Debugf("closing %d connections\n", total)
+	}
+	a.conns.wait(0)
+}
+
+// newConnectionWaiter accepts new connections until listener is closed
+func (a *acceptor) newConnectionWaiter(c chan<- net.Conn, errc chan<- error) {
+	for {
+		a.log.Debugf("waiting for new connection\n")
+		conn, err := a.listener.Accept()
+		if err != nil {
+			// listener of ssh client returns io.EOF when the ssh connection is lost
+			if errors.Is(err, io.EOF) {
+				err = errors.New("listener is closed, ssh connection may be lost")
+			}
+			// errc is buffered, and nobody receives it if the listener is closed by serve
+			if !errors.Is(err, net.ErrClosed) {
+				errc <- err
+			}
+			return
+		}
+		select {
+		case c <- conn:
+		case <-a.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// stop send close signal and wait for all connections closed if it's started
+func (a *acceptor) stop() {
+	a.closeOnce.Do(func() {
+		close(a.close)
+	})
+
+	if a.started() {
+		<-a.done
+	}
+}
@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	unix = "unix"
+	tcp  = "tcp"
+)
+
+// Addr is an endpoint of a tunnel
+type Addr struct {
+	Network string // "unix" or "tcp"
+	Address string // socket path for unix, host:port for tcp
+}
+
+// ParseAddr parse address in the form of "unix:///path/to/socket" or "tcp://host:port",
+// address without scheme is considered as a unix socket path
+func ParseAddr(addr string) (Addr, error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		if addr == "" {
+			return Addr{}, fmt.Errorf("empty address")
+		}
+		return Addr{Network: unix, Address: addr}, nil
+	}
+
+	network, address := addr[:i], addr[i+len("://"):]
+	switch network {
+	case unix:
+		if address == "" {
+			return Addr{}, fmt.Errorf("invalid address %q: empty socket path", addr)
+		}
+	case tcp:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return Addr{}, fmt.Errorf("invalid address %q: %v", addr, err)
+		}
+	default:
+		return Addr{}, fmt.Errorf("invalid address %q: unsupported scheme %q", addr, network)
+	}
+	return Addr{Network: network, Address: address}, nil
+}
+
+// String return address with scheme, e.g. "unix:///var/run/docker.sock"
+func (a Addr) String() string {
+	return a.Network + "://" + a.Address
+}
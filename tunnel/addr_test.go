@@ -0,0 +1,45 @@
+package tunnel
+
+import "testing"
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		want    Addr
+		wantErr bool
+	}{
+		{addr: "/var/run/docker.sock", want: Addr{Network: "unix", Address: "/var/run/docker.sock"}},
+		{addr: "relative/docker.sock", want: Addr{Network: "unix", Address: "relative/docker.sock"}},
+		{addr: "unix:///var/run/docker.sock", want: Addr{Network: "unix", Address: "/var/run/docker.sock"}},
+		{addr: "tcp://127.0.0.1:2375", want: Addr{Network: "tcp", Address: "127.0.0.1:2375"}},
+		{addr: "tcp://[::1]:0", want: Addr{Network: "tcp", Address: "[::1]:0"}},
+		{addr: "tcp://localhost:2375", want: Addr{Network: "tcp", Address: "localhost:2375"}},
+		{addr: "", wantErr: true},
+		{addr: "unix://", wantErr: true},
+		{addr: "tcp://127.0.0.1", wantErr: true},
+		{addr: "tcp://", wantErr: true},
+		{addr: "http://127.0.0.1:2375", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseAddr(c.addr)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseAddr(%q) error = %v, wantErr %v", c.addr, err, c.wantErr)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseAddr(%q) = %+v, want %+v", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestAddrString(t *testing.T) {
+	for _, addr := range []string{"unix:///var/run/docker.sock", "tcp://127.0.0.1:2375"} {
+		a, err := ParseAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.String() != addr {
+			t.Errorf("ParseAddr(%q).String() = %q", addr, a.String())
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnectionInfo describes an active connection of a tunnel
+type ConnectionInfo struct {
+	ID         uint64    // unique in a tunnel, starting from 1
+	ListenAddr string    // address the connection is accepted on
+	DialAddr   string    // address the connection is forwarded to
+	StartedAt  time.Time // when the connection is accepted
+}
+
+type trackedConn struct {
+	info ConnectionInfo
+	conn net.Conn // the accepted connection
+}
+
+// connRegistry tracks active connections of a tunnel, it's safe for concurrent use
+type connRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+	idle   []chan struct{} // closed when all connections are removed
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{
+		conns: make(map[uint64]*trackedConn),
+	}
+}
+
+// add register a new connection and return its id
+func (r *connRegistry) add(conn net.Conn, dialAddr string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	id := r.nextID
+	var listenAddr string
+	if addr := conn.LocalAddr(); addr != nil {
+		listenAddr = addr.String()
+	}
+	r.conns[id] = &trackedConn{
+		info: ConnectionInfo{
+			ID:         id,
+			ListenAddr: listenAddr,
+			DialAddr:   dialAddr,
+			StartedAt:  time.Now(),
+		},
+		conn: conn,
+	}
+	return id
+}
+
+// remove unregister a finished connection
+func (r *connRegistry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.conns[id]; !ok {
+		return
+	}
+	delete(r.conns, id)
+	if len(r.conns) == 0 {
+		for _, idle := range r.idle {
+			close(idle)
+		}
+		r.idle = nil
+	}
+}
+
+// list return info of all active connections ordered by id
+func (r *connRegistry) list() []ConnectionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	infos := make([]ConnectionInfo, 0, len(r.conns))
+	for _, c := range r.conns {
+		infos = append(infos, c.info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// closeAll close accepted side of all active connections, so that their forwarding ends soon,
+// it returns the number of closed connections
+func (r *connRegistry) closeAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.conns {
+		c.conn.Close()
+	}
+	return len(r.conns)
+}
+
+// wait for all connections removed, or timeout, it returns false on timeout. Zero timeout waits forever
+func (r *connRegistry) wait(timeout time.Duration) bool {
+	r.mu.Lock()
+	if len(r.conns) == 0 {
+		r.mu.Unlock()
+		return true
+	}
+	done := make(chan struct{})
+	r.idle = append(r.idle, done)
+	r.mu.Unlock()
+
+	// a nil channel blocks forever
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-done:
+		return true
+	case <-expired:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for i, idle := range r.idle {
+			if idle == done {
+				r.idle = append(r.idle[:i], r.idle[i+1:]...)
+				break
+			}
+		}
+		return false
+	}
+}
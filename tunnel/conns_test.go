@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnRegistry(t *testing.T) {
+	r := newConnRegistry()
+	a, peerA := net.Pipe()
+	b, peerB := net.Pipe()
+	defer peerA.Close()
+	defer peerB.Close()
+
+	idA := r.add(a, "unix:///remote.sock")
+	idB := r.add(b, "tcp://127.0.0.1:2375")
+	if idA != 1 || idB != 2 {
+		t.Fatalf("ids = %d, %d, want 1, 2", idA, idB)
+	}
+	infos := r.list()
+	if len(infos) != 2 || infos[0].ID != idA || infos[1].ID != idB {
+		t.Fatalf("list = %+v, want connections %d and %d", infos, idA, idB)
+	}
+	if infos[0].ListenAddr != "pipe" || infos[1].DialAddr != "tcp://127.0.0.1:2375" || infos[0].StartedAt.IsZero() {
+		t.Errorf("info = %+v, %+v", infos[0], infos[1])
+	}
+
+	r.remove(idA)
+	// removing a connection twice mustn't break the wait group
+	r.remove(idA)
+	if infos := r.list(); len(infos) != 1 || infos[0].ID != idB {
+		t.Fatalf("list = %+v, want connection %d", infos, idB)
+	}
+	if r.wait(10 * time.Millisecond) {
+		t.Error("wait = true with an active connection, want timeout")
+	}
+
+	if n := r.closeAll(); n != 1 {
+		t.Errorf("closeAll = %d, want 1", n)
+	}
+	if _, err := peerB.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read of closed connection = %v, want EOF", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.remove(idB)
+	}()
+	if !r.wait(time.Second) {
+		t.Error("wait = false after all connections are removed, want true")
+	}
+	if !r.wait(0) {
+		t.Error("wait without timeout = false, want true")
+	}
+	if id := r.add(a, ""); id != 3 {
+		t.Errorf("id after remove = %d, want 3", id)
+	}
+}
@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+// Dialer dials the remote socket directly over ssh, no local socket file is needed
+type Dialer struct {
+	remote    Addr
+	remoteErr error // error of parsing remote address
+	sshConn   *sshConn
+	ownsConn  bool // false if ssh connection is shared by Manager
+}
+
+// NewDialer create a new Dialer, remoteSocket can be "unix:///path/to/socket",
+// "tcp://host:port" or a unix socket path without scheme
+func NewDialer(remoteSocket string, sshClient *ssh.Client) *Dialer {
+	return newDialer(remoteSocket, newSSHConn(sshClient, nil, &log.NoopLogger{}), true)
+}
+
+// NewDialerWithFactory create a new Dialer which dials ssh client with factory,
+// and redials it when the ssh connection is dead
+func NewDialerWithFactory(remoteSocket string, factory ClientFactory) *Dialer {
+	return newDialer(remoteSocket, newSSHConn(nil, factory, &log.NoopLogger{}), true)
+}
+
+// NewDialerWithClient create a new Dialer over sshClient dialed by factory,
+// the ssh client is redialed with factory when it's dead, and it's closed by Close
+func NewDialerWithClient(remoteSocket string, sshClient *ssh.Client, factory ClientFactory) *Dialer {
+	return newDialer(remoteSocket, newSSHConn(sshClient, factory, &log.NoopLogger{}), true)
+}
+
+func newDialer(remoteSocket string, sshConn *sshConn, ownsConn bool) *Dialer {
+	remote, err := ParseAddr(remoteSocket)
+	if err != nil {
+		err = fmt.Errorf("invalid remote socket: %v", err)
+	}
+	return &Dialer{
+		remote:    remote,
+		remoteErr: err,
+		sshConn:   sshConn,
+		ownsConn:  ownsConn,
+	}
+}
+
+// SetLogger set custom logger, logs are disabled by default,
+// it's ignored if the ssh connection is shared by Manager
+func (d *Dialer) SetLogger(logger log.Logger) *Dialer {
+	if d.ownsConn {
+		d.sshConn.log = logger
+	}
+	return d
+}
+
+// SetReconnectBackoff set min and max wait time between reconnect attempts, default is 1s and 30s
+func (d *Dialer) SetReconnectBackoff(min, max time.Duration) *Dialer {
+	d.sshConn.minBackoff = min
+	d.sshConn.maxBackoff = max
+	return d
+}
+
+// OnReconnect set hook called after every reconnect attempt
+func (d *Dialer) OnReconnect(hook ReconnectHook) *Dialer {
+	d.sshConn.hook = hook
+	return d
+}
+
+// SetKeepAlive send keepalive request every interval, the ssh connection is considered broken
+// after maxMissed continuous replies are missed, default is 30s and 3, zero interval disables keepalive
+func (d *Dialer) SetKeepAlive(interval time.Duration, maxMissed int) *Dialer {
+	d.sshConn.keepAliveInterval = interval
+	d.sshConn.keepAliveMaxMiss = maxMissed
+	return d
+}
+
+// State return the state of the ssh connection
+func (d *Dialer) State() State {
+	return d.sshConn.state()
+}
+
+// RemoteAddr return the address of remote socket
+func (d *Dialer) RemoteAddr() Addr {
+	return d.remote
+}
+
+// Dial open a new connection to remote socket
+func (d *Dialer) Dial() (net.Conn, error) {
+	return d.DialContext(context.Background(), d.remote.Network, d.remote.Address)
+}
+
+// DialContext open a new connection to remote socket, network and addr are ignored,
+// so it can be used as the dial function of http.Transport, docker client or grpc
+func (d *Dialer) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	if d.remoteErr != nil {
+		return nil, d.remoteErr
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	// ssh.Client.Dial doesn't accept context, so wait for it in a goroutine
+	c := make(chan result, 1)
+	go func() {
+		conn, err := d.sshConn.dial(ctx, d.remote.Network, d.remote.Address)
+		c <- result{conn: conn, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// close the connection if it's established after context is done
+		go func() {
+			if r := <-c; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-c:
+		if r.err != nil {
+			return nil, fmt.Errorf("failed to dial remote socket: %v", r.err)
+		}
+		return r.conn, nil
+	}
+}
+
+// ContextDialer is same as DialContext but with the signature of grpc.WithContextDialer
+func (d *Dialer) ContextDialer(ctx context.Context, addr string) (net.Conn, error) {
+	return d.DialContext(ctx, d.remote.Network, addr)
+}
+
+// Close stop watching the ssh connection, the ssh client is closed only if it's created by factory,
+// it does nothing if the ssh connection is shared by Manager
+func (d *Dialer) Close() {
+	if d.ownsConn {
+		d.sshConn.close()
+	}
+}
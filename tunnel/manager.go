@@ -0,0 +1,269 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+// Tunnel is implemented by SocketTunnel and ReverseTunnel
+type Tunnel interface {
+	Start(ctx context.Context) (<-chan error, error)
+	Stop()
+	State() State
+	ActiveConnections() []ConnectionInfo
+
+	// endpoints return the address tunnel listens on and the address connections are forwarded to
+	endpoints() (listen, dial Addr)
+	// shared return the ssh connection of tunnel
+	shared() *sshConn
+}
+
+// TunnelStatus describes a tunnel registered in Manager
+type TunnelStatus struct {
+	Name              string
+	Kind              string // "forward" or "reverse"
+	ListenAddr        string // address tunnel listens on
+	DialAddr          string // address connections are forwarded to
+	Running           bool
+	ActiveConnections int
+	Err               error // why the tunnel exited, nil if it's running
+}
+
+type managedTunnel struct {
+	tunnel  Tunnel
+	started chan struct{} // closed when Start of tunnel returns, tunnel is stopped after it
+	running bool
+	err     error
+}
+
+// Manager manages named tunnels sharing one ssh connection
+type Manager struct {
+	mu      sync.Mutex
+	tunnels map[string]*managedTunnel
+	closed  bool
+
+	sshConn *sshConn
+	log     log.Logger
+}
+
+// NewManager create a new Manager over sshClient
+func NewManager(sshClient *ssh.Client) *Manager {
+	return newManager(newSSHConn(sshClient, nil, logrus.New()))
+}
+
+// NewManagerWithFactory create a new Manager which dials ssh client with factory,
+// and redials it when the ssh connection is dead
+func NewManagerWithFactory(factory ClientFactory) *Manager {
+	return newManager(newSSHConn(nil, factory, logrus.New()))
+}
+
+// NewManagerWithClient create a new Manager over sshClient dialed by factory, e.g. to check errors before starting tunnels.
+// The ssh client is redialed with factory when it's dead, and it's closed by Close like clients created by factory
+func NewManagerWithClient(sshClient *ssh.Client, factory ClientFactory) *Manager {
+	return newManager(newSSHConn(sshClient, factory, logrus.New()))
+}
+
+func newManager(sshConn *sshConn) *Manager {
+	return &Manager{
+		tunnels: make(map[string]*managedTunnel),
+		sshConn: sshConn,
+		log:     sshConn.log,
+	}
+}
+
+// SetLogger set custom logger of manager and the ssh connection,
+// tunnels created after it use the logger by default
+func (m *Manager) SetLogger(logger log.Logger) *Manager {
+	m.log = logger
+	m.sshConn.log = logger
+	return m
+}
+
+// DisableLogger disable all logs
+func (m *Manager) DisableLogger() *Manager {
+	return m.SetLogger(&log.NoopLogger{})
+}
+
+// SetReconnectBackoff set min and max wait time between reconnect attempts, default is 1s and 30s
+func (m *Manager) SetReconnectBackoff(min, max time.Duration) *Manager {
+	m.sshConn.minBackoff = min
+	m.sshConn.maxBackoff = max
+	return m
+}
+
+// OnReconnect set hook called after every reconnect attempt
+func (m *Manager) OnReconnect(hook ReconnectHook) *Manager {
+	m.sshConn.hook = hook
+	return m
+}
+
+// SetKeepAlive send keepalive request every interval, the ssh connection is considered broken
+// after maxMissed continuous replies are missed, default is 30s and 3, zero interval disables keepalive
+func (m *Manager) SetKeepAlive(interval time.Duration, maxMissed int) *Manager {
+	m.sshConn.keepAliveInterval = interval
+	m.sshConn.keepAliveMaxMiss = maxMissed
+	return m
+}
+
+// State return the state of the shared ssh connection
+func (m *Manager) State() State {
+	return m.sshConn.state()
+}
+
+// NewSocketTunnel create a SocketTunnel sharing the ssh connection of manager,
+// it's not started until it's registered by Add
+func (m *Manager) NewSocketTunnel(localSocket, remoteSocket string) *SocketTunnel {
+	return newSocketTunnel(localSocket, remoteSocket, m.sshConn, false)
+}
+
+// NewReverseTunnel create a ReverseTunnel sharing the ssh connection of manager,
+// it's not started until it's registered by Add
+func (m *Manager) NewReverseTunnel(remoteSocket, localSocket string) *ReverseTunnel {
+	return newReverseTunnel(remoteSocket, localSocket, m.sshConn, false)
+}
+
+// NewDialer create a Dialer sharing the ssh connection of manager, it isn't registered in manager
+func (m *Manager) NewDialer(remoteSocket string) *Dialer {
+	return newDialer(remoteSocket, m.sshConn, false)
+}
+
+// Add register a tunnel created by manager with name and start it
+func (m *Manager) Add(name string, tunnel Tunnel) error {
+	if tunnel.shared() != m.sshConn {
+		return fmt.Errorf("tunnel %q is not created by this manager", name)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	if _, ok := m.tunnels[name]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("tunnel %q already exists", name)
+	}
+	// reserve the name, starting may take a while if the ssh connection is being redialed
+	mt := &managedTunnel{tunnel: tunnel, started: make(chan struct{})}
+	m.tunnels[name] = mt
+	m.mu.Unlock()
+
+	errc, err := tunnel.Start(context.Background())
+	m.mu.Lock()
+	// Remove and Close may be called meanwhile, they stop the tunnel after it's started
+	removed := m.tunnels[name] != mt
+	if err != nil {
+		if !removed {
+			delete(m.tunnels, name)
+		}
+		m.mu.Unlock()
+		close(mt.started)
+		return fmt.Errorf("failed to start tunnel %q: %v", name, err)
+	}
+	if removed {
+		m.mu.Unlock()
+		close(mt.started)
+		return ErrClosed
+	}
+	mt.running = true
+	m.mu.Unlock()
+	close(mt.started)
+	m.log.Infof("tunnel %q is started\n", name)
+
+	go func() {
+		err := <-errc
+		m.mu.Lock()
+		mt.running = false
+		mt.err = err
+		m.mu.Unlock()
+		if err != nil {
+			m.log.Errorf("tunnel %q exited: %v\n", name, err)
+		}
+	}()
+	return nil
+}
+
+// Get return the tunnel registered with name
+func (m *Manager) Get(name string) (Tunnel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt, ok := m.tunnels[name]
+	if !ok {
+		return nil, false
+	}
+	return mt.tunnel, true
+}
+
+// Remove stop the tunnel registered with name and unregister it, blocking method
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	mt, ok := m.tunnels[name]
+	delete(m.tunnels, name)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("tunnel %q doesn't exist", name)
+	}
+
+	<-mt.started
+	mt.tunnel.Stop()
+	m.log.Infof("tunnel %q is removed\n", name)
+	return nil
+}
+
+// Status return status of all registered tunnels ordered by name
+func (m *Manager) Status() []TunnelStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]TunnelStatus, 0, len(m.tunnels))
+	for name, mt := range m.tunnels {
+		listen, dial := mt.tunnel.endpoints()
+		kind := "forward"
+		if _, ok := mt.tunnel.(*ReverseTunnel); ok {
+			kind = "reverse"
+		}
+		statuses = append(statuses, TunnelStatus{
+			Name:              name,
+			Kind:              kind,
+			ListenAddr:        listen.String(),
+			DialAddr:          dial.String(),
+			Running:           mt.running,
+			ActiveConnections: len(mt.tunnel.ActiveConnections()),
+			Err:               mt.err,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Close stop all tunnels and the ssh connection, blocking method.
+// The ssh client is closed only if it's created by factory
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	tunnels := m.tunnels
+	m.tunnels = make(map[string]*managedTunnel)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, mt := range tunnels {
+		wg.Add(1)
+		go func(name string, mt *managedTunnel) {
+			defer wg.Done()
+			<-mt.started
+			mt.tunnel.Stop()
+			m.log.Debugf("tunnel %q is stopped\n", name)
+		}(name, mt)
+	}
+	wg.Wait()
+
+	m.sshConn.close()
+}
@@ -0,0 +1,292 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+const (
+	defaultMinBackoff        = 1 * time.Second
+	defaultMaxBackoff        = 30 * time.Second
+	defaultKeepAliveInterval = 30 * time.Second
+	defaultKeepAliveMaxMiss  = 3
+	defaultDialTimeout       = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned when dialing over a closed tunnel
+	ErrClosed = errors.New("tunnel is closed")
+	// ErrBroken is returned when dialing over a tunnel whose ssh connection is dead and can't be redialed
+	ErrBroken = errors.New("tunnel is broken")
+)
+
+// State is the state of the ssh connection of a tunnel
+type State int
+
+const (
+	// StateIdle means the ssh connection is not dialed yet
+	StateIdle State = iota
+	// StateConnected means the ssh connection is alive
+	StateConnected
+	// StateReconnecting means the ssh connection is dead and being redialed
+	StateReconnecting
+	// StateBroken means the ssh connection is dead and there is no factory to redial it
+	StateBroken
+	// StateClosed means the tunnel is stopped
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateBroken:
+		return "broken"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ClientFactory creates a new ssh client, it's used to redial when the ssh connection is dead
+type ClientFactory func() (*ssh.Client, error)
+
+// ReconnectEvent describes an attempt to redial the ssh connection
+type ReconnectEvent struct {
+	Attempt int           // attempt number, starting from 1
+	Err     error         // error of this attempt, nil if reconnected
+	Delay   time.Duration // wait time before next attempt, zero if reconnected
+}
+
+// ReconnectHook is called after every reconnect attempt
+type ReconnectHook func(event ReconnectEvent)
+
+// sshConn holds the ssh client shared by all connections of a tunnel,
+// it watches the client and redials it with factory when it's dead
+type sshConn struct {
+	mu           sync.Mutex
+	client       *ssh.Client
+	factory      ClientFactory
+	err          error         // why the client is lost, or why the first client failed to dial
+	connected    bool          // a client has been established, dial errors before it are returned to callers
+	reconnecting chan struct{} // closed when current reconnect loop finished, nil if not reconnecting
+	watching     bool          // is current client watched
+	closed       bool
+	closing      chan struct{}
+
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+	keepAliveInterval time.Duration // zero means keepalive is disabled
+	keepAliveMaxMiss  int           // max continuous missed keepalive replies before the connection is broken
+	hook              ReconnectHook
+	log               log.Logger
+}
+
+func newSSHConn(client *ssh.Client, factory ClientFactory, logger log.Logger) *sshConn {
+	return &sshConn{
+		client:            client,
+		factory:           factory,
+		connected:         client != nil,
+		closing:           make(chan struct{}),
+		minBackoff:        defaultMinBackoff,
+		maxBackoff:        defaultMaxBackoff,
+		keepAliveInterval: defaultKeepAliveInterval,
+		keepAliveMaxMiss:  defaultKeepAliveMaxMiss,
+		log:               logger,
+	}
+}
+
+// get return current ssh client, wait for reconnecting if the client is dead.
+// If no client has been established yet, the error of dialing is returned instead of retrying forever,
+// e.g. the host key is rejected, and the next call dials again
+func (c *sshConn) get(ctx context.Context) (*ssh.Client, error) {
+	for attempted := false; ; attempted = true {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if c.client != nil {
+			client := c.client
+			c.startWatching()
+			c.mu.Unlock()
+			return client, nil
+		}
+		if c.factory == nil {
+			err := c.err
+			c.mu.Unlock()
+			return nil, fmt.Errorf("%w: %v", ErrBroken, err)
+		}
+		if attempted && !c.connected && c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return nil, fmt.Errorf("failed to dial ssh connection: %w", err)
+		}
+		if c.reconnecting == nil {
+			c.reconnecting = make(chan struct{})
+			go c.reconnect()
+		}
+		wait := c.reconnecting
+		c.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-c.closing:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// startWatching watch current client if it's not watched, caller must hold the lock
+func (c *sshConn) startWatching() {
+	if c.client != nil && !c.watching {
+		c.watching = true
+		go c.watch(c.client)
+	}
+}
+
+// dial remote address over current ssh client
+func (c *sshConn) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// state return current state of the ssh connection
+func (c *sshConn) state() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closed:
+		return StateClosed
+	case c.client != nil:
+		return StateConnected
+	case c.reconnecting != nil:
+		return StateReconnecting
+	case c.err != nil && c.factory == nil:
+		return StateBroken
+	}
+	return StateIdle
+}
+
+// watch the client until it's dead or sshConn is closed, the watcher is shared with other ssh connections over the client
+func (c *sshConn) watch(client *ssh.Client) {
+	w := watchClient(client, c.keepAliveInterval, c.keepAliveMaxMiss, c.log)
+	defer w.release()
+
+	select {
+	case <-c.closing:
+	case <-w.dead:
+		c.lost(client, w.err)
+	}
+}
+
+// lost mark the client as dead, and start reconnecting if there is a factory
+func (c *sshConn) lost(client *ssh.Client, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != client || c.closed {
+		return
+	}
+
+	c.log.Warnf("ssh connection is lost: %v\n", err)
+	c.client = nil
+	c.watching = false
+	c.err = err
+	if c.factory != nil && c.reconnecting == nil {
+		c.reconnecting = make(chan struct{})
+		go c.reconnect()
+	}
+}
+
+// reconnect redial ssh client with backoff until it's succeeded or sshConn is closed.
+// The first client is dialed only once, its error is returned to callers by get
+func (c *sshConn) reconnect() {
+	delay := c.minBackoff
+	for attempt := 1; ; attempt++ {
+		c.log.Debugf("dialing ssh connection (attempt %d)\n", attempt)
+		client, err := c.factory()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				client.Close()
+				return
+			}
+			c.client = client
+			c.err = nil
+			c.connected = true
+			c.watching = true
+			go c.watch(client)
+			close(c.reconnecting)
+			c.reconnecting = nil
+			c.mu.Unlock()
+
+			c.log.Infof("ssh connection is established (attempt %d)\n", attempt)
+			c.notify(ReconnectEvent{Attempt: attempt})
+			return
+		}
+
+		c.mu.Lock()
+		if !c.connected {
+			c.err = err
+			close(c.reconnecting)
+			c.reconnecting = nil
+			c.mu.Unlock()
+			c.log.Warnf("failed to dial ssh connection: %v\n", err)
+			c.notify(ReconnectEvent{Attempt: attempt, Err: err})
+			return
+		}
+		c.mu.Unlock()
+
+		c.log.Warnf("failed to dial ssh connection (attempt %d), retrying in %v: %v\n", attempt, delay, err)
+		c.notify(ReconnectEvent{Attempt: attempt, Err: err, Delay: delay})
+
+		select {
+		case <-c.closing:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > c.maxBackoff {
+			delay = c.maxBackoff
+		}
+	}
+}
+
+func (c *sshConn) notify(event ReconnectEvent) {
+	if c.hook != nil {
+		c.hook(event)
+	}
+}
+
+// close stop watching and reconnecting, the client is closed only if there is a factory to create it
+func (c *sshConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.closing)
+	if c.factory != nil && c.client != nil {
+		c.client.Close()
+	}
+}
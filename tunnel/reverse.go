@@ -0,0 +1,239 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/internal/shell"
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+// ReverseTunnel forwards connections of remote socket to local socket, like `ssh -R`,
+// both of them can be a unix socket or a tcp address
+type ReverseTunnel struct {
+	remote                 Addr
+	local                  Addr
+	addrErr                error // error of parsing remote or local address
+	autoRemoveRemoteSocket bool
+	sshConn                *sshConn
+	ownsConn               bool // false if ssh connection is shared by Manager
+	releaseOnce            sync.Once
+
+	acceptor // listener for remote socket and active connections
+}
+
+// NewReverseTunnel create a new ReverseTunnel, remoteSocket and localSocket can be "unix:///path/to/socket",
+// "tcp://host:port" or a unix socket path without scheme
+func NewReverseTunnel(remoteSocket, localSocket string, sshClient *ssh.Client) *ReverseTunnel {
+	return newReverseTunnel(remoteSocket, localSocket, newSSHConn(sshClient, nil, logrus.New()), true)
+}
+
+func newReverseTunnel(remoteSocket, localSocket string, sshConn *sshConn, ownsConn bool) *ReverseTunnel {
+	remote, err := ParseAddr(remoteSocket)
+	if err != nil {
+		err = fmt.Errorf("invalid remote socket: %v", err)
+	}
+	local, localErr := ParseAddr(localSocket)
+	if localErr != nil && err == nil {
+		err = fmt.Errorf("invalid local socket: %v", localErr)
+	}
+	return &ReverseTunnel{
+		remote:   remote,
+		local:    local,
+		addrErr:  err,
+		sshConn:  sshConn,
+		ownsConn: ownsConn,
+		acceptor: newAcceptor(sshConn.log),
+	}
+}
+
+// SetLogger set custom logger, logger of the ssh connection is not changed if it's shared by Manager
+func (tunnel *ReverseTunnel) SetLogger(logger log.Logger) *ReverseTunnel {
+	tunnel.log = logger
+	if tunnel.ownsConn {
+		tunnel.sshConn.log = logger
+	}
+	return tunnel
+}
+
+// DisableLogger disable all logs
+func (tunnel *ReverseTunnel) DisableLogger() *ReverseTunnel {
+	return tunnel.SetLogger(&log.NoopLogger{})
+}
+
+// AutoRemoveRemoteSocket remove remote socket before start/close tunnel, it's ignored for tcp address
+func (tunnel *ReverseTunnel) AutoRemoveRemoteSocket() *ReverseTunnel {
+	tunnel.autoRemoveRemoteSocket = true
+	return tunnel
+}
+
+// SetKeepAlive send keepalive request every interval, the ssh connection is considered broken
+// after maxMissed continuous replies are missed, default is 30s and 3, zero interval disables keepalive.
+// The ssh connection options are shared by all tunnels if the tunnel is created by Manager.
+func (tunnel *ReverseTunnel) SetKeepAlive(interval time.Duration, maxMissed int) *ReverseTunnel {
+	tunnel.sshConn.keepAliveInterval = interval
+	tunnel.sshConn.keepAliveMaxMiss = maxMissed
+	return tunnel
+}
+
+// SetDrainTimeout set max wait time for active connections to finish when tunnel is stopping,
+// the rest connections are closed after timeout, default is 0 which closes them immediately
+func (tunnel *ReverseTunnel) SetDrainTimeout(timeout time.Duration) *ReverseTunnel {
+	tunnel.drainTimeout = timeout
+	return tunnel
+}
+
+// State return the state of the ssh connection
+func (tunnel *ReverseTunnel) State() State {
+	return tunnel.sshConn.state()
+}
+
+// Start listen on remote socket and serve connections in background.
+// Errors before listening (e.g. listen error) are returned directly,
+// the returned channel receives the result of serving after tunnel exits.
+// Tunnel exits when ctx is done, Stop is called or the ssh connection is lost,
+// it's cleaned up like Stop when ctx is done, so calling Stop is not required then.
+func (tunnel *ReverseTunnel) Start(ctx context.Context) (<-chan error, error) {
+	if err := tunnel.listen(ctx); err != nil {
+		return nil, err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tunnel.run(ctx)
+		close(errc)
+	}()
+	return errc, nil
+}
+
+// Run tunnel until ctx is done, Stop is called or the ssh connection is lost, blocking method.
+// It's cleaned up like Stop when ctx is done
+func (tunnel *ReverseTunnel) Run(ctx context.Context) error {
+	if err := tunnel.listen(ctx); err != nil {
+		return err
+	}
+	return tunnel.run(ctx)
+}
+
+// run serve connections, and release the tunnel if ctx is done
+func (tunnel *ReverseTunnel) run(ctx context.Context) error {
+	err := tunnel.serve(ctx, tunnel.local.String(), tunnel.forward)
+	if ctx.Err() != nil {
+		tunnel.release()
+	}
+	return err
+}
+
+// RemoteAddr return the address remote socket is listening on, it's useful for tcp address with port 0,
+// it returns the configured address before tunnel is started
+func (tunnel *ReverseTunnel) RemoteAddr() Addr {
+	if tunnel.started() && tunnel.remote.Network == tcp {
+		return Addr{Network: tcp, Address: tunnel.listener.Addr().String()}
+	}
+	return tunnel.remote
+}
+
+// listen on remote socket
+func (tunnel *ReverseTunnel) listen(ctx context.Context) error {
+	if tunnel.addrErr != nil {
+		return tunnel.addrErr
+	}
+	if tunnel.listener != nil {
+		return errors.New("tunnel is already started")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	client, err := tunnel.sshConn.get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get ssh connection: %v", err)
+	}
+	if err := tunnel.removeRemoteSocket(client); err != nil {
+		return err
+	}
+
+	tunnel.log.Debugf("starting reverse tunnel from %s to %s\n", tunnel.remote, tunnel.local)
+	listener, err := client.Listen(tunnel.remote.Network, tunnel.remote.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on remote socket: %v", err)
+	}
+	tunnel.setListener(listener)
+
+	return nil
+}
+
+// forward connection to local socket
+func (tunnel *ReverseTunnel) forward(ctx context.Context, remote net.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	local, err := dialer.DialContext(ctx, tunnel.local.Network, tunnel.local.Address)
+	if err != nil {
+		remote.Close()
+		return fmt.Errorf("failed to dial local socket: %v", err)
+	}
+
+	runTunnel(local, remote)
+	return nil
+}
+
+// Stop tunnel, blocking method
+func (tunnel *ReverseTunnel) Stop() {
+	// ensure all connections are closed
+	tunnel.stop()
+	tunnel.release()
+}
+
+// release remove remote socket and close the ssh connection if it's owned, it's done only once
+func (tunnel *ReverseTunnel) release() {
+	tunnel.releaseOnce.Do(func() {
+		if tunnel.autoRemoveRemoteSocket && tunnel.remote.Network == unix {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+			client, err := tunnel.sshConn.get(ctx)
+			cancel()
+			if err == nil {
+				err = tunnel.removeRemoteSocket(client)
+			}
+			if err != nil {
+				tunnel.log.Errorf("failed to remove remote socket file: %v", err)
+			}
+		}
+
+		if tunnel.ownsConn {
+			tunnel.sshConn.close()
+		}
+	})
+}
+
+func (tunnel *ReverseTunnel) endpoints() (listen, dial Addr) {
+	return tunnel.RemoteAddr(), tunnel.local
+}
+
+func (tunnel *ReverseTunnel) shared() *sshConn {
+	return tunnel.sshConn
+}
+
+// remove remoteSocket if exists, sshd doesn't replace an existing socket file by default
+func (tunnel *ReverseTunnel) removeRemoteSocket(client *ssh.Client) error {
+	if !tunnel.autoRemoveRemoteSocket || tunnel.remote.Network != unix {
+		return nil
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create ssh session: %v", err)
+	}
+	defer session.Close()
+
+	if out, err := session.CombinedOutput("rm -f " + shell.Quote(tunnel.remote.Address)); err != nil {
+		return fmt.Errorf("failed to remove remote socket file: %v: %s", err, out)
+	}
+	return nil
+}
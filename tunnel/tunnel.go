@@ -1,12 +1,15 @@
 package tunnel
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -14,42 +17,101 @@ import (
 	"github.com/aFlyBird0/sshcontainer/log"
 )
 
-const unix = "unix"
-
-// SocketTunnel is a tunnel for unix socket
+// SocketTunnel forwards connections of local socket to remote socket,
+// both of them can be a unix socket or a tcp address
 type SocketTunnel struct {
-	localSocket           string
-	remoteSocket          string
+	local                 Addr
+	remote                Addr
+	addrErr               error // error of parsing local or remote address
 	autoRemoveLocalSocket bool
-	log                   log.Logger
-	sshClient             *ssh.Client
+	sshConn               *sshConn
+	ownsConn              bool // false if ssh connection is shared by Manager
+	releaseOnce           sync.Once
 
-	conns    []net.Conn    // all connections
-	close    chan struct{} // close signal
-	isOpen   bool          // is tunnel open
-	done     chan struct{} // is all connection closed
-	listener net.Listener  // listener for local socket
+	acceptor // listener for local socket and active connections
 }
 
-// NewSocketTunnel create a new SocketTunnel
+// NewSocketTunnel create a new SocketTunnel, localSocket and remoteSocket can be "unix:///path/to/socket",
+// "tcp://host:port" or a unix socket path without scheme
 func NewSocketTunnel(localSocket, remoteSocket string, sshClient *ssh.Client) *SocketTunnel {
+	return newSocketTunnel(localSocket, remoteSocket, newSSHConn(sshClient, nil, logrus.New()), true)
+}
+
+// NewSocketTunnelWithFactory create a new SocketTunnel which dials ssh client with factory,
+// and redials it when the ssh connection is dead
+func NewSocketTunnelWithFactory(localSocket, remoteSocket string, factory ClientFactory) *SocketTunnel {
+	return newSocketTunnel(localSocket, remoteSocket, newSSHConn(nil, factory, logrus.New()), true)
+}
+
+// NewSocketTunnelWithClient create a new SocketTunnel over sshClient dialed by factory,
+// the ssh client is redialed with factory when it's dead, and it's closed by Stop
+func NewSocketTunnelWithClient(localSocket, remoteSocket string, sshClient *ssh.Client, factory ClientFactory) *SocketTunnel {
+	return newSocketTunnel(localSocket, remoteSocket, newSSHConn(sshClient, factory, logrus.New()), true)
+}
+
+func newSocketTunnel(localSocket, remoteSocket string, sshConn *sshConn, ownsConn bool) *SocketTunnel {
+	local, err := ParseAddr(localSocket)
+	if err != nil {
+		err = fmt.Errorf("invalid local socket: %v", err)
+	}
+	remote, remoteErr := ParseAddr(remoteSocket)
+	if remoteErr != nil && err == nil {
+		err = fmt.Errorf("invalid remote socket: %v", remoteErr)
+	}
 	return &SocketTunnel{
-		localSocket:  localSocket,
-		remoteSocket: remoteSocket,
-		log:          logrus.New(),
-		sshClient:    sshClient,
-		close:        make(chan struct{}, 1),
-		done:         make(chan struct{}, 1),
+		local:    local,
+		remote:   remote,
+		addrErr:  err,
+		sshConn:  sshConn,
+		ownsConn: ownsConn,
+		acceptor: newAcceptor(sshConn.log),
 	}
 }
 
-// SetLogger set custom logger
+// SetLogger set custom logger, logger of the ssh connection is not changed if it's shared by Manager
 func (tunnel *SocketTunnel) SetLogger(logger log.Logger) *SocketTunnel {
 	tunnel.log = logger
+	if tunnel.ownsConn {
+		tunnel.sshConn.log = logger
+	}
+	return tunnel
+}
+
+// SetReconnectBackoff set min and max wait time between reconnect attempts, default is 1s and 30s.
+// The ssh connection options are shared by all tunnels if the tunnel is created by Manager.
+func (tunnel *SocketTunnel) SetReconnectBackoff(min, max time.Duration) *SocketTunnel {
+	tunnel.sshConn.minBackoff = min
+	tunnel.sshConn.maxBackoff = max
 	return tunnel
 }
 
-// AutoRemoveLocalSocket remove local socket before start/close tunnel
+// OnReconnect set hook called after every reconnect attempt
+func (tunnel *SocketTunnel) OnReconnect(hook ReconnectHook) *SocketTunnel {
+	tunnel.sshConn.hook = hook
+	return tunnel
+}
+
+// SetKeepAlive send keepalive request every interval, the ssh connection is considered broken
+// after maxMissed continuous replies are missed, default is 30s and 3, zero interval disables keepalive
+func (tunnel *SocketTunnel) SetKeepAlive(interval time.Duration, maxMissed int) *SocketTunnel {
+	tunnel.sshConn.keepAliveInterval = interval
+	tunnel.sshConn.keepAliveMaxMiss = maxMissed
+	return tunnel
+}
+
+// SetDrainTimeout set max wait time for active connections to finish when tunnel is stopping,
+// the rest connections are closed after timeout, default is 0 which closes them immediately
+func (tunnel *SocketTunnel) SetDrainTimeout(timeout time.Duration) *SocketTunnel {
+	tunnel.drainTimeout = timeout
+	return tunnel
+}
+
+// State return the state of the ssh connection
+func (tunnel *SocketTunnel) State() State {
+	return tunnel.sshConn.state()
+}
+
+// AutoRemoveLocalSocket remove local socket before start/close tunnel, it's ignored for tcp address
 func (tunnel *SocketTunnel) AutoRemoveLocalSocket() *SocketTunnel {
 	tunnel.autoRemoveLocalSocket = true
 	return tunnel
@@ -57,75 +119,97 @@ func (tunnel *SocketTunnel) AutoRemoveLocalSocket() *SocketTunnel {
 
 // DisableLogger disable all logs
 func (tunnel *SocketTunnel) DisableLogger() *SocketTunnel {
-	tunnel.log = &log.NoopLogger{}
-	return tunnel
+	return tunnel.SetLogger(&log.NoopLogger{})
 }
 
-// Start tunnel, you should call this method in a goroutine
-func (tunnel *SocketTunnel) Start() (err error) {
-	// mkdir -p if not exists
-	if err = os.MkdirAll(filepath.Dir(tunnel.localSocket), 0755); err != nil {
-		return fmt.Errorf("failed to create local socket directory: %v", err)
+// Start listen on local socket and serve connections in background.
+// Errors before listening (e.g. listen error) are returned directly,
+// the returned channel receives the result of serving after tunnel exits.
+// Tunnel exits when ctx is done or Stop is called, it's cleaned up like Stop when ctx is done,
+// so calling Stop is not required then.
+func (tunnel *SocketTunnel) Start(ctx context.Context) (<-chan error, error) {
+	if err := tunnel.listen(); err != nil {
+		return nil, err
 	}
-	if err = tunnel.removeLocalSocket(); err != nil {
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tunnel.run(ctx)
+		close(errc)
+	}()
+	return errc, nil
+}
+
+// Run tunnel until ctx is done or Stop is called, blocking method.
+// It's cleaned up like Stop when ctx is done
+func (tunnel *SocketTunnel) Run(ctx context.Context) error {
+	if err := tunnel.listen(); err != nil {
 		return err
 	}
+	return tunnel.run(ctx)
+}
 
-	tunnel.log.Debugf("starting tunnel from %s to %s\n", tunnel.localSocket, tunnel.remoteSocket)
-	tunnel.listener, err = net.Listen(unix, tunnel.localSocket)
-	if err != nil {
-		return fmt.Errorf("failed to listen on local socket: %v", err)
+// run serve connections, and release the tunnel if ctx is done
+func (tunnel *SocketTunnel) run(ctx context.Context) error {
+	err := tunnel.serve(ctx, tunnel.remote.String(), tunnel.forward)
+	if ctx.Err() != nil {
+		tunnel.release()
 	}
+	return err
+}
 
-	defer tunnel.listener.Close()
+// LocalAddr return the address local socket is listening on, it's useful for tcp address with port 0,
+// it returns the configured address before tunnel is started
+func (tunnel *SocketTunnel) LocalAddr() Addr {
+	if tunnel.started() && tunnel.local.Network == tcp {
+		return Addr{Network: tcp, Address: tunnel.listener.Addr().String()}
+	}
+	return tunnel.local
+}
 
-	defer func() {
-		total := len(tunnel.conns)
-		for i, conn := range tunnel.conns {
-			tunnel.log.Debugf("closing the netConn (%d of %d)\n", i+1, total)
-			err := conn.Close()
-			if err != nil && !errors.Is(err, net.ErrClosed) {
-				tunnel.log.Errorf("failed to close netConn: %v\n", err)
-			}
+// listen on local socket
+func (tunnel *SocketTunnel) listen() (err error) {
+	if tunnel.addrErr != nil {
+		return tunnel.addrErr
+	}
+	if tunnel.listener != nil {
+		return errors.New("tunnel is already started")
+	}
+	if tunnel.local.Network == unix {
+		// mkdir -p if not exists
+		if err = os.MkdirAll(filepath.Dir(tunnel.local.Address), 0755); err != nil {
+			return fmt.Errorf("failed to create local socket directory: %v", err)
 		}
-	}()
-
-	tunnel.isOpen = true
-
-	for tunnel.isOpen {
-		c := make(chan net.Conn)
-		go tunnel.newConnectionWaiter(tunnel.listener, c)
-
-		select {
-		case <-tunnel.close:
-			// close signal received
-			tunnel.log.Debugf("received close signal\n")
-			tunnel.isOpen = false
-		case conn := <-c:
-			tunnel.conns = append(tunnel.conns, conn)
-			tunnel.log.Debugf("accepted connection\n")
-
-			go func() {
-				err := tunnel.forward(conn)
-				if err != nil {
-					tunnel.log.Errorf("failed to forward connection: %v\n", err)
-				}
-			}()
+		if err = tunnel.removeLocalSocket(); err != nil {
+			return err
 		}
 	}
 
-	/// ensure all connections are closed
-	tunnel.done <- struct{}{}
+	tunnel.log.Debugf("starting tunnel from %s to %s\n", tunnel.local, tunnel.remote)
+	listener, err := net.Listen(tunnel.local.Network, tunnel.local.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on local socket: %v", err)
+	}
+	tunnel.setListener(listener)
+
+	// detect dead ssh connection before any connection comes
+	tunnel.sshConn.mu.Lock()
+	tunnel.sshConn.startWatching()
+	tunnel.sshConn.mu.Unlock()
 
 	return nil
 }
 
 // forward connection to remote socket
-func (tunnel *SocketTunnel) forward(local net.Conn) error {
+func (tunnel *SocketTunnel) forward(ctx context.Context, local net.Conn) error {
 	// Issue a dial to the remote server on our SSH client; here "localhost"
 	// refers to the remote server.
-	remote, err := tunnel.sshClient.Dial(unix, tunnel.remoteSocket)
+	// don't wait for reconnecting forever
+	ctx, cancel := context.WithTimeout(ctx, defaultDialTimeout)
+	defer cancel()
+	remote, err := tunnel.sshConn.dial(ctx, tunnel.remote.Network, tunnel.remote.Address)
 	if err != nil {
+		local.Close()
 		return fmt.Errorf("failed to dial remote socket: %v", err)
 	}
 
@@ -133,56 +217,84 @@ func (tunnel *SocketTunnel) forward(local net.Conn) error {
 	return nil
 }
 
-// runTunnel copies data between local and remote
+// closeWriter is implemented by connections supporting half-close,
+// such as *net.UnixConn, *net.TCPConn and connections dialed by ssh client
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// runTunnel copies data between local and remote until both directions are finished.
+// When one side finishes writing, the write side of the other one is closed, so that
+// half-closed streams (e.g. docker attach, exec and cp) work as on a local socket.
 func runTunnel(local, remote net.Conn) {
 	defer local.Close()
 	defer remote.Close()
 	done := make(chan struct{}, 2)
 
 	go func() {
-		io.Copy(local, remote)
+		pipe(local, remote)
 		done <- struct{}{}
 	}()
 
 	go func() {
-		io.Copy(remote, local)
+		pipe(remote, local)
 		done <- struct{}{}
 	}()
 
 	<-done
+	<-done
 }
 
-// newConnectionWaiter waits for new connection
-func (tunnel *SocketTunnel) newConnectionWaiter(listener net.Listener, c chan net.Conn) {
-	tunnel.log.Debugf("waiting for new connection\n")
-	conn, err := listener.Accept()
-	if err != nil && !errors.Is(err, net.ErrClosed) {
-		tunnel.log.Errorf("failed to accept connection: %v\n", err)
-		return
+// pipe copies data from src to dst, then propagates EOF to dst.
+// Both connections are closed if copying fails or dst doesn't support half-close.
+func pipe(dst, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	if err == nil {
+		if cw, ok := dst.(closeWriter); ok {
+			if cw.CloseWrite() == nil {
+				return
+			}
+		}
 	}
-	c <- conn
+	dst.Close()
+	src.Close()
 }
 
 // Stop tunnel, blocking method
 func (tunnel *SocketTunnel) Stop() {
-	tunnel.close <- struct{}{}
-	close(tunnel.close)
+	// ensure all connections are closed
+	tunnel.stop()
+	tunnel.release()
+}
 
-	if err := tunnel.removeLocalSocket(); err != nil {
-		tunnel.log.Errorf("failed to remove local socket file: %v", err)
-	}
+// release remove local socket and close the ssh connection if it's owned, it's done only once
+func (tunnel *SocketTunnel) release() {
+	tunnel.releaseOnce.Do(func() {
+		if err := tunnel.removeLocalSocket(); err != nil {
+			tunnel.log.Errorf("failed to remove local socket file: %v", err)
+		}
 
-	// ensure all connections are closed
-	<-tunnel.done
+		if tunnel.ownsConn {
+			tunnel.sshConn.close()
+		}
+	})
+}
+
+func (tunnel *SocketTunnel) endpoints() (listen, dial Addr) {
+	return tunnel.LocalAddr(), tunnel.remote
+}
+
+func (tunnel *SocketTunnel) shared() *sshConn {
+	return tunnel.sshConn
 }
 
 // remove localSocket if exists
 func (tunnel *SocketTunnel) removeLocalSocket() error {
-	if !tunnel.autoRemoveLocalSocket {
+	if !tunnel.autoRemoveLocalSocket || tunnel.local.Network != unix {
 		return nil
 	}
-	if _, err := os.Stat(tunnel.localSocket); err == nil {
-		if err := os.Remove(tunnel.localSocket); err != nil {
+	if _, err := os.Stat(tunnel.local.Address); err == nil {
+		if err := os.Remove(tunnel.local.Address); err != nil {
 			return fmt.Errorf("failed to remove local socket file: %v", err)
 		}
 	}
@@ -0,0 +1,142 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unixPair return two connected unix connections
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	dir, err := os.MkdirTemp("", "tunnel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("unix", filepath.Join(dir, "pair.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("failed to accept connection")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed.(*net.UnixConn), conn.(*net.UnixConn)
+}
+
+func TestRunTunnelHalfClose(t *testing.T) {
+	client, local := unixPair(t)
+	remote, server := unixPair(t)
+	done := make(chan struct{})
+	go func() {
+		runTunnel(local, remote)
+		close(done)
+	}()
+
+	// the server replies after the request is finished, like `docker cp` uploads
+	go func() {
+		request, _ := io.ReadAll(server)
+		server.Write(append([]byte("reply to "), request...))
+		server.CloseWrite()
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "reply to request" {
+		t.Errorf("reply = %q, want %q", reply, "reply to request")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runTunnel doesn't return after both directions are finished")
+	}
+}
+
+// brokenConn fails to read, and records whether it's closed
+type brokenConn struct {
+	net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *brokenConn) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func (c *brokenConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func TestPipeErrorClosesBothEnds(t *testing.T) {
+	dst, peer := unixPair(t)
+	srcConn, _ := unixPair(t)
+	src := &brokenConn{Conn: srcConn}
+
+	pipe(dst, src)
+
+	src.mu.Lock()
+	closed := src.closed
+	src.mu.Unlock()
+	if !closed {
+		t.Error("src isn't closed after read error")
+	}
+	if _, err := dst.Write([]byte("x")); err == nil {
+		t.Error("dst is still writable after read error of src, want it closed")
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read of dst peer = %v, want EOF", err)
+	}
+}
+
+func TestPipeWithoutHalfCloseClosesBothEnds(t *testing.T) {
+	// net.Pipe doesn't support half-close
+	dst, peer := net.Pipe()
+	defer peer.Close()
+	src, srcPeer := unixPair(t)
+
+	go srcPeer.CloseWrite()
+	go io.Copy(io.Discard, peer)
+	pipe(dst, src)
+
+	if _, err := dst.Write([]byte("x")); err == nil {
+		t.Error("dst is still writable, want it closed")
+	}
+	srcPeer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := srcPeer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read of src peer = %v, want EOF", err)
+	}
+}
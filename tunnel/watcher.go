@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/aFlyBird0/sshcontainer/log"
+)
+
+// clientWatcher watches an ssh client shared by several tunnels, dialers and managers,
+// so that only one goroutine waits for the client and one keepalive loop runs over it
+type clientWatcher struct {
+	client        *ssh.Client
+	dead          chan struct{} // closed when the client is dead
+	err           error         // why the client is dead, it's set before dead is closed
+	refs          int
+	stopKeepAlive chan struct{} // closed when nobody watches the client, nil if keepalive isn't running
+}
+
+var (
+	watchersMu sync.Mutex
+	watchers   = make(map[*ssh.Client]*clientWatcher)
+)
+
+// watchClient return the watcher of client, the watcher must be released after use.
+// Keepalive of a client watched by several ssh connections uses the options of the first one
+func watchClient(client *ssh.Client, interval time.Duration, maxMissed int, logger log.Logger) *clientWatcher {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	w, ok := watchers[client]
+	if !ok {
+		w = &clientWatcher{client: client, dead: make(chan struct{})}
+		watchers[client] = w
+		go w.wait()
+	}
+	w.refs++
+	// a zero interval disables keepalive
+	if w.stopKeepAlive == nil && interval > 0 {
+		w.stopKeepAlive = make(chan struct{})
+		go w.keepAlive(w.stopKeepAlive, interval, maxMissed, logger)
+	}
+	return w
+}
+
+// release stop keepalive if nobody watches the client, the client is still waited until it's closed
+func (w *clientWatcher) release() {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	w.refs--
+	if w.refs == 0 && w.stopKeepAlive != nil {
+		close(w.stopKeepAlive)
+		w.stopKeepAlive = nil
+	}
+}
+
+// wait for the client to be closed by the server, the network or its owner
+func (w *clientWatcher) wait() {
+	err := w.client.Wait()
+	watchersMu.Lock()
+	delete(watchers, w.client)
+	if w.err == nil {
+		if err == nil {
+			err = errors.New("ssh connection closed")
+		}
+		w.err = err
+	}
+	watchersMu.Unlock()
+	close(w.dead)
+}
+
+// keepAlive send keepalive requests every interval until stop is closed or the client is dead,
+// the client is closed after maxMissed continuous replies are missed
+func (w *clientWatcher) keepAlive(stop <-chan struct{}, interval time.Duration, maxMissed int, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-w.dead:
+			return
+		case <-ticker.C:
+			err := keepAlive(w.client, interval)
+			if err == nil {
+				missed = 0
+				continue
+			}
+			missed++
+			logger.Debugf("missed keepalive reply (%d of %d): %v\n", missed, maxMissed, err)
+			if missed >= maxMissed {
+				watchersMu.Lock()
+				if w.err == nil {
+					w.err = fmt.Errorf("missed %d keepalive replies: %v", missed, err)
+				}
+				watchersMu.Unlock()
+				// close the client so that in-flight connections are closed instead of hanging
+				w.client.Close()
+				return
+			}
+		}
+	}
+}
+
+// keepAlive send a keepalive request and wait for the reply
+func keepAlive(client *ssh.Client, timeout time.Duration) error {
+	reply := make(chan error, 1)
+	go func() {
+		// the reply is an error for OpenSSH servers, it doesn't matter because the server is alive
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no keepalive reply in %v", timeout)
+	}
+}